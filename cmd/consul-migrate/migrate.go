@@ -23,8 +23,10 @@ func main() {
 	app := cli.NewCLI("consul-migrate", migrate.Version)
 	app.Args = os.Args[1:]
 	app.Commands = map[string]cli.CommandFactory{
-		"export": func() (cli.Command, error) { return commands.NewExport(ui) },
-		"import": func() (cli.Command, error) { return commands.NewImport(ui) },
+		"export":   func() (cli.Command, error) { return commands.NewExport(ui) },
+		"import":   func() (cli.Command, error) { return commands.NewImport(ui) },
+		"rollback": func() (cli.Command, error) { return commands.NewRollback(ui) },
+		"snapshot": func() (cli.Command, error) { return commands.NewSnapshot(ui) },
 	}
 
 	exitStatus, err := app.Run()
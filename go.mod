@@ -3,8 +3,9 @@ module github.com/mkeeler/consul-migrate
 go 1.15
 
 require (
-	github.com/hashicorp/consul/api v1.8.1
+	github.com/hashicorp/consul/api v1.12.0
 	github.com/hashicorp/go-hclog v0.15.0
+	github.com/hashicorp/go-msgpack v0.5.5
 	github.com/kr/text v0.1.0
 	github.com/mitchellh/cli v1.1.2
 )
@@ -0,0 +1,269 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// The maps of the importer which a checkpoint restores
+const (
+	checkpointPolicyMap         = "policy"
+	checkpointRoleMap           = "role"
+	checkpointAuthMethodMap     = "auth-method"
+	checkpointTokenSecretMap    = "token-secret"
+	checkpointRenamedRoles      = "renamed-role"
+	checkpointCreatedNamespaces = "created-namespace"
+)
+
+// checkpointNamespaceACLDefaults is the kind of the ACL defaults of a namespace within a
+// checkpoint. They are imported separately from the namespace itself.
+const checkpointNamespaceACLDefaults = "namespace-acl-defaults"
+
+// checkpointHeader is the first line of a checkpoint. It ties the checkpoint to the
+// data being imported and the options which decide how its IDs and names are mapped
+// so that an import is not resumed with different data or options.
+type checkpointHeader struct {
+	InputHash string `json:"input_hash"`
+}
+
+// checkpointMapping is an entry the import added to one of its maps
+type checkpointMapping struct {
+	Map  string `json:"map"`
+	From string `json:"from"`
+	To   string `json:"to,omitempty"`
+}
+
+// checkpointRecord is a single object of the imported data that was imported
+type checkpointRecord struct {
+	// Key identifies the object within the imported data
+	Key      string              `json:"key"`
+	Mappings []checkpointMapping `json:"mappings,omitempty"`
+}
+
+// Checkpoint records the progress of an import so that an interrupted import can be
+// resumed. It starts with a hash of the imported data and an entry is appended after
+// each object along with what the object added to the maps from source ids to target
+// ids. A resumed import skips the objects which
+// were imported and rebuilds the maps from the recorded entries. A nil Checkpoint
+// records nothing.
+type Checkpoint struct {
+	file     *os.File
+	enc      *json.Encoder
+	imported map[string]bool
+	mappings []checkpointMapping
+}
+
+// CheckpointInputHash returns the hash a checkpoint is tied to. It covers the imported
+// data and the options which change the ids and names the objects get on the target,
+// as the maps restored from the checkpoint would not match those of an import with
+// other options.
+func CheckpointInputHash(data []byte, opts *ImportOptions) string {
+	hash := sha256.New()
+	hash.Write(data)
+
+	if opts != nil {
+		sources := make([]string, 0, len(opts.DatacenterMap))
+		for source := range opts.DatacenterMap {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			fmt.Fprintf(hash, "\x00dc-map=%s:%s", source, opts.DatacenterMap[source])
+		}
+		fmt.Fprintf(hash, "\x00on-conflict=%s", opts.OnConflict)
+		fmt.Fprintf(hash, "\x00rename-suffix=%s", opts.RenameSuffix)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// NewCheckpoint creates the checkpoint for a new import of the data with the given hash
+// at the given path, replacing any checkpoint of an earlier import there
+func NewCheckpoint(path, inputHash string) (*Checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error creating checkpoint: %w", err)
+	}
+
+	c := &Checkpoint{file: f, enc: json.NewEncoder(f), imported: make(map[string]bool)}
+	if err := c.enc.Encode(checkpointHeader{InputHash: inputHash}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error syncing checkpoint: %w", err)
+	}
+	return c, nil
+}
+
+// ResumeCheckpoint reads the checkpoint of an interrupted import at the given path and
+// opens it to record the progress of the resumed import. It fails when the checkpoint
+// was recorded for data or options other than those with the given hash.
+func ResumeCheckpoint(path, inputHash string) (*Checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checkpoint: %w", err)
+	}
+
+	c := &Checkpoint{file: f, enc: json.NewEncoder(f), imported: make(map[string]bool)}
+
+	reader := bufio.NewReader(f)
+	data, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+	var header checkpointHeader
+	if err != nil || json.Unmarshal(data, &header) != nil || header.InputHash == "" {
+		f.Close()
+		return nil, fmt.Errorf("checkpoint does not start with the hash of the imported data")
+	}
+	if header.InputHash != inputHash {
+		f.Close()
+		return nil, fmt.Errorf("checkpoint was recorded for different data or import options")
+	}
+
+	// offset is the end of the last complete entry
+	offset := int64(len(data))
+	for line := 2; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(data) > 0 {
+				// the import died while writing the last entry so its object
+				// gets imported again
+				if err := f.Truncate(offset); err != nil {
+					f.Close()
+					return nil, fmt.Errorf("error removing incomplete checkpoint entry: %w", err)
+				}
+			}
+			break
+		} else if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error reading checkpoint: %w", err)
+		}
+
+		offset += int64(len(data))
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		var record checkpointRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error decoding checkpoint entry on line %d: %w", line, err)
+		}
+		c.imported[record.Key] = true
+		c.mappings = append(c.mappings, record.Mappings...)
+	}
+
+	return c, nil
+}
+
+// Imported returns the number of objects the checkpoint records as imported
+func (c *Checkpoint) Imported() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.imported)
+}
+
+// Close closes the checkpoint file
+func (c *Checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+func (c *Checkpoint) done(key string) bool {
+	return c != nil && c.imported[key]
+}
+
+// record appends an imported object to the checkpoint and waits for it to be on disk
+func (c *Checkpoint) record(key string, mappings []checkpointMapping) error {
+	if c == nil {
+		return nil
+	}
+
+	if err := c.enc.Encode(checkpointRecord{Key: key, Mappings: mappings}); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := c.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing checkpoint: %w", err)
+	}
+
+	c.imported[key] = true
+	return nil
+}
+
+// restore rebuilds the maps of the importer from the checkpoint
+func (c *Checkpoint) restore(imp *importer) {
+	if c == nil {
+		return
+	}
+
+	for _, mapping := range c.mappings {
+		switch mapping.Map {
+		case checkpointPolicyMap:
+			imp.policyMap[mapping.From] = mapping.To
+		case checkpointRoleMap:
+			imp.roleMap[mapping.From] = mapping.To
+		case checkpointAuthMethodMap:
+			imp.authMethodMap[mapping.From] = mapping.To
+		case checkpointTokenSecretMap:
+			imp.tokenSecretMap[mapping.From] = mapping.To
+		case checkpointRenamedRoles:
+			imp.renamedRoles[mapping.From] = mapping.To
+		case checkpointCreatedNamespaces:
+			imp.createdNamespaces[mapping.From] = true
+		}
+	}
+}
+
+// checkpointKey identifies an object of the imported data within a checkpoint by its
+// kind, the partition and namespace it is imported into and its id in the source data
+func checkpointKey(kind, partition, namespace, id string) string {
+	return kind + ":" + partition + "/" + namespace + "/" + id
+}
+
+// scopedKey identifies an object imported into the importer's partition and namespace
+func (imp *importer) scopedKey(kind, id string) string {
+	namespace := ""
+	if imp.opts != nil {
+		namespace = imp.opts.Namespace
+	}
+	return checkpointKey(kind, imp.partition, namespace, id)
+}
+
+// alreadyImported reports whether an object was imported before the import was resumed
+func (imp *importer) alreadyImported(key string) bool {
+	if !imp.checkpoint.done(key) {
+		return false
+	}
+	imp.logger.Debug("skipping object imported before resuming", "key", key)
+	return true
+}
+
+// checkpointed records an object as imported along with what it added to the maps
+func (imp *importer) checkpointed(key string, mappings ...checkpointMapping) error {
+	return imp.checkpoint.record(key, mappings)
+}
+
+// once imports an object unless it was imported before the import was resumed
+func (imp *importer) once(key string, fn func() error) error {
+	if imp.alreadyImported(key) {
+		return nil
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return imp.checkpointed(key)
+}
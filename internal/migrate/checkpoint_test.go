@@ -0,0 +1,147 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResumeCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "import.checkpoint")
+
+	hash := CheckpointInputHash([]byte(`{"Datacenter":"dc1"}`), nil)
+	checkpoint, err := NewCheckpoint(path, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := checkpointMapping{Map: checkpointPolicyMap, From: "source-id", To: "target-id"}
+	if err := checkpoint.record(checkpointKey(JournalACLPolicy, "", "", "source-id"), []checkpointMapping{mapping}); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint.Close()
+
+	// the import died while writing the next entry
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"key":"ACL Role`)
+	f.Close()
+
+	_, err = ResumeCheckpoint(path, CheckpointInputHash([]byte(`{"Datacenter":"dc2"}`), nil))
+	if err == nil || !strings.Contains(err.Error(), "different data") {
+		t.Fatalf("expected the checkpoint of other data to be rejected, got %v", err)
+	}
+
+	checkpoint, err = ResumeCheckpoint(path, hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer checkpoint.Close()
+
+	if checkpoint.Imported() != 1 || !checkpoint.done(checkpointKey(JournalACLPolicy, "", "", "source-id")) {
+		t.Fatalf("expected the policy to be imported, got %v", checkpoint.imported)
+	}
+	if len(checkpoint.mappings) != 1 || checkpoint.mappings[0] != mapping {
+		t.Fatalf("expected the policy mapping, got %+v", checkpoint.mappings)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(contents), "ACL Role") {
+		t.Fatalf("expected the incomplete entry to be removed, got %s", contents)
+	}
+}
+
+func TestResumeCheckpoint_WithoutHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "import.checkpoint")
+
+	// a checkpoint written before it was tied to its data
+	if err := ioutil.WriteFile(path, []byte(`{"key":"ACL Policy://source-id"}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ResumeCheckpoint(path, CheckpointInputHash(nil, nil))
+	if err == nil || !strings.Contains(err.Error(), "hash of the imported data") {
+		t.Fatalf("expected the checkpoint without a hash to be rejected, got %v", err)
+	}
+}
+
+func TestCheckpointInputHash(t *testing.T) {
+	data := []byte(`{"Datacenter":"dc1"}`)
+	base := &ImportOptions{
+		DatacenterMap: map[string]string{"dc1": "east", "dc2": "west"},
+		OnConflict:    ConflictRename,
+		RenameSuffix:  "-imported",
+	}
+	hash := CheckpointInputHash(data, base)
+
+	cases := map[string]struct {
+		data []byte
+		opts *ImportOptions
+		same bool
+	}{
+		"same options": {
+			data: data,
+			opts: &ImportOptions{
+				DatacenterMap: map[string]string{"dc2": "west", "dc1": "east"},
+				OnConflict:    ConflictRename,
+				RenameSuffix:  "-imported",
+				// importing the CA does not change the ids or names of other objects
+				ImportConnectCA: true,
+			},
+			same: true,
+		},
+		"different data": {
+			data: []byte(`{"Datacenter":"dc2"}`),
+			opts: base,
+		},
+		"different dc map": {
+			data: data,
+			opts: &ImportOptions{
+				DatacenterMap: map[string]string{"dc1": "east"},
+				OnConflict:    ConflictRename,
+				RenameSuffix:  "-imported",
+			},
+		},
+		"different on conflict": {
+			data: data,
+			opts: &ImportOptions{
+				DatacenterMap: base.DatacenterMap,
+				OnConflict:    ConflictSkip,
+				RenameSuffix:  "-imported",
+			},
+		},
+		"different rename suffix": {
+			data: data,
+			opts: &ImportOptions{
+				DatacenterMap: base.DatacenterMap,
+				OnConflict:    ConflictRename,
+				RenameSuffix:  "-migrated",
+			},
+		},
+	}
+
+	for name, tcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			same := CheckpointInputHash(tcase.data, tcase.opts) == hash
+			if same != tcase.same {
+				t.Fatalf("expected the hashes to match: %v, got %v", tcase.same, same)
+			}
+		})
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"os"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
@@ -16,9 +16,12 @@ type exportCommand struct {
 	flags *flag.FlagSet
 	http  *httpFlags
 
-	output  string
-	verbose bool
-	silent  bool
+	output         string
+	verbose        bool
+	silent         bool
+	includeSecrets bool
+	// configEntryKinds are additional config entry kinds to export as raw JSON
+	configEntryKinds sliceValue
 }
 
 func NewExport(ui cli.Ui) (cli.Command, error) {
@@ -30,7 +33,15 @@ func NewExport(ui cli.Ui) (cli.Command, error) {
 
 	c.flags.BoolVar(&c.silent, "silent", false, "Disables all normal log output")
 	c.flags.BoolVar(&c.verbose, "verbose", false, "Enable verbose debugging output")
-	c.flags.StringVar(&c.output, "output", "", "File path to output the data to. Defaults to stdout")
+	c.flags.StringVar(&c.output, "output", "", "File path to output the data to. "+
+		"The file is only readable by its owner as the data holds secrets in plaintext. Defaults to stdout")
+	c.flags.BoolVar(&c.includeSecrets, "include-secrets", false, "Export data holding private keys, such as "+
+		"the Connect CA configuration and the gossip encryption keys. ACL token secrets are always exported")
+	c.flags.Var(&c.configEntryKinds, "config-entry-kind", "Additional `kind` of config entries to export as "+
+		"raw JSON, for kinds which are newer than consul-migrate. Consul cannot list the kinds it knows, so "+
+		"such kinds are only exported when given here. A warning is logged for each kind exported as raw JSON and "+
+		"when the source runs a newer Consul version than consul-migrate knows the kinds of. May be specified "+
+		"multiple times")
 
 	flagMerge(c.flags, c.http.flags())
 
@@ -72,7 +83,10 @@ func (c *exportCommand) Run(args []string) int {
 	}
 
 	hclog.L().Info("starting data export")
-	data, err := migrate.Export(client)
+	data, err := migrate.Export(client, &migrate.ExportOptions{
+		IncludeSecrets:   c.includeSecrets,
+		ConfigEntryKinds: c.configEntryKinds,
+	})
 	if err != nil {
 		hclog.L().Error("error exporting data", "error", err)
 		return 1
@@ -87,7 +101,7 @@ func (c *exportCommand) Run(args []string) int {
 	if c.output == "" {
 		c.ui.Output(string(serialized))
 	} else {
-		if err := ioutil.WriteFile(c.output, serialized, 0600); err != nil {
+		if err := writeSecretFile(c.output, serialized); err != nil {
 			hclog.L().Error("failed to write data to file", "file", c.output, "error", err)
 			return 1
 		}
@@ -97,6 +111,24 @@ func (c *exportCommand) Run(args []string) int {
 	return 0
 }
 
+// writeSecretFile writes data holding secrets to a file only readable by its owner. The
+// permissions of an existing file are tightened before anything is written to it.
+func writeSecretFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 const exportHelp = `
 Usage: consul-migrate export [options] <output>
 
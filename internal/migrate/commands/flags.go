@@ -2,6 +2,9 @@ package commands
 
 import (
 	"flag"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/hashicorp/consul/api"
 )
@@ -112,3 +115,42 @@ func (s *stringValue) String() string {
 	}
 	return current
 }
+
+// mapValue provides a flag value that collects old=new pairs into a map. The
+// flag may be given multiple times.
+type mapValue map[string]string
+
+// Set implements the flag.Value interface.
+func (m mapValue) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("%q is not of the form old=new", v)
+	}
+	m[parts[0]] = parts[1]
+	return nil
+}
+
+// String implements the flag.Value interface.
+func (m mapValue) String() string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// sliceValue provides a flag value that collects every value it is given. The
+// flag may be given multiple times.
+type sliceValue []string
+
+// Set implements the flag.Value interface.
+func (s *sliceValue) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// String implements the flag.Value interface.
+func (s *sliceValue) String() string {
+	return strings.Join(*s, ",")
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/mkeeler/consul-migrate/internal/migrate"
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 )
@@ -20,6 +23,25 @@ type importCommand struct {
 	input   string
 	verbose bool
 	silent  bool
+	dcMap   mapValue
+
+	// authMethodTokens is the mode for handling tokens issued by auth methods
+	authMethodTokens string
+	// onConflict is the mode for handling objects which already exist on the target
+	onConflict   string
+	renameSuffix string
+	// importConnectCA opts into importing the Connect CA configuration
+	importConnectCA bool
+
+	// dryRun prints the plan of the import instead of importing
+	dryRun bool
+	format string
+	// journal is the file to record the changes of the import in
+	journal string
+	// checkpoint is the file to record the progress of the import in and
+	// resume is the checkpoint of an interrupted import to continue
+	checkpoint string
+	resume     string
 }
 
 func NewImport(ui cli.Ui) (cli.Command, error) {
@@ -27,11 +49,42 @@ func NewImport(ui cli.Ui) (cli.Command, error) {
 		ui:    ui,
 		http:  &httpFlags{},
 		flags: flag.NewFlagSet("", flag.ContinueOnError),
+		dcMap: make(mapValue),
 	}
 
 	c.flags.BoolVar(&c.silent, "silent", false, "Disables all normal log output")
 	c.flags.BoolVar(&c.verbose, "verbose", false, "Enable verbose debugging output")
 	c.flags.StringVar(&c.input, "input", "", "File path to read data from. Defaults to stdin")
+	c.flags.Var(c.dcMap, "dc-map", "Rename references to a source datacenter in the form old=new. "+
+		"Applies to ACL policies, service and node identities, prepared query failover and network "+
+		"area peers. May be specified multiple times")
+	c.flags.StringVar(&c.authMethodTokens, "auth-method-tokens", string(migrate.AuthMethodTokensSkip),
+		"How to handle tokens issued by logging in through an auth method. One of "+
+			"\"skip\" to leave them out so that clients log in again, \"recreate\" to create them "+
+			"as regular tokens or \"fail\" to abort the import")
+	c.flags.StringVar(&c.onConflict, "on-conflict", string(migrate.ConflictOverwrite),
+		"How to handle objects which already exist on the target but differ from the imported ones. One of "+
+			"\"fail\" to abort the import, \"skip\" to keep the object of the target, \"overwrite\" to update "+
+			"it or \"rename\" to import policies and roles under a new name and keep all other objects, such as KV "+
+			"and config entries, as with \"skip\"")
+	c.flags.StringVar(&c.renameSuffix, "rename-suffix", "-imported",
+		"The suffix added to the names of policies and roles renamed by -on-conflict=rename. "+
+			"When the renamed name is taken by a differing object as well, it is numbered as in -imported-2")
+	c.flags.BoolVar(&c.importConnectCA, "import-connect-ca", false, "Import the Connect CA configuration "+
+		"of the data. When the target already issues certificates this rotates its CA")
+	c.flags.BoolVar(&c.dryRun, "dry-run", false, "Print what the import would do with partitions, "+
+		"namespaces, ACL policies, roles and tokens without writing anything to the target")
+	c.flags.StringVar(&c.format, "format", "pretty", "Output format of -dry-run. One of \"pretty\" or \"json\"")
+	c.flags.StringVar(&c.journal, "journal", "", "File path to record every object the import creates or "+
+		"updates in so that consul-migrate rollback can undo the import. The file is appended to. The journal "+
+		"holds token secrets, and gossip encryption keys in plaintext, so it is only readable by its owner")
+	c.flags.StringVar(&c.checkpoint, "checkpoint", "", "File path to record the progress of the import in "+
+		"after each object so that an interrupted import can be continued with -resume. The checkpoint "+
+		"holds the secret ids of the imported tokens and of the tokens created for them in plaintext")
+	c.flags.StringVar(&c.resume, "resume", "", "File path of the checkpoint of an interrupted import. The "+
+		"objects imported before are skipped and the checkpoint keeps recording the progress. The "+
+		"checkpoint is rejected when it was recorded for different data or with different -dc-map, "+
+		"-on-conflict or -rename-suffix options")
 
 	flagMerge(c.flags, c.http.flags())
 	return c, nil
@@ -56,6 +109,42 @@ func (c *importCommand) Run(args []string) int {
 		return 1
 	}
 
+	authMethodTokens := migrate.AuthMethodTokenMode(c.authMethodTokens)
+	switch authMethodTokens {
+	case migrate.AuthMethodTokensSkip, migrate.AuthMethodTokensRecreate, migrate.AuthMethodTokensFail:
+	default:
+		c.ui.Error(fmt.Sprintf("Invalid -auth-method-tokens value: %q", c.authMethodTokens))
+		return 1
+	}
+
+	onConflict := migrate.ConflictMode(c.onConflict)
+	switch onConflict {
+	case migrate.ConflictFail, migrate.ConflictSkip, migrate.ConflictOverwrite, migrate.ConflictRename:
+	default:
+		c.ui.Error(fmt.Sprintf("Invalid -on-conflict value: %q", c.onConflict))
+		return 1
+	}
+
+	if onConflict == migrate.ConflictRename && c.renameSuffix == "" {
+		c.ui.Error("-rename-suffix cannot be empty with -on-conflict=rename")
+		return 1
+	}
+
+	if c.format != "pretty" && c.format != "json" {
+		c.ui.Error(fmt.Sprintf("Invalid -format value: %q", c.format))
+		return 1
+	}
+
+	if c.checkpoint != "" && c.resume != "" {
+		c.ui.Error("Cannot specify both -checkpoint and -resume")
+		return 1
+	}
+
+	if c.dryRun && (c.checkpoint != "" || c.resume != "") {
+		c.ui.Error("Cannot specify -checkpoint or -resume with -dry-run")
+		return 1
+	}
+
 	level := hclog.Info
 	if c.verbose {
 		level = hclog.Debug
@@ -92,7 +181,50 @@ func (c *importCommand) Run(args []string) int {
 		return 1
 	}
 
-	err = migrate.Import(client, &data)
+	opts := &migrate.ImportOptions{
+		DatacenterMap:    c.dcMap,
+		AuthMethodTokens: authMethodTokens,
+		OnConflict:       onConflict,
+		RenameSuffix:     c.renameSuffix,
+		ImportConnectCA:  c.importConnectCA,
+	}
+
+	if c.dryRun {
+		return c.plan(client, &data, opts)
+	}
+
+	if c.journal != "" {
+		journal, err := migrate.OpenJournal(c.journal)
+		if err != nil {
+			hclog.L().Error("error opening journal", "file", c.journal, "error", err)
+			return 1
+		}
+		defer journal.Close()
+		opts.Journal = journal
+	}
+
+	if c.checkpoint != "" {
+		checkpoint, err := migrate.NewCheckpoint(c.checkpoint, migrate.CheckpointInputHash(dataBytes, opts))
+		if err != nil {
+			hclog.L().Error("error creating checkpoint", "file", c.checkpoint, "error", err)
+			return 1
+		}
+		defer checkpoint.Close()
+		opts.Checkpoint = checkpoint
+	}
+
+	if c.resume != "" {
+		checkpoint, err := migrate.ResumeCheckpoint(c.resume, migrate.CheckpointInputHash(dataBytes, opts))
+		if err != nil {
+			hclog.L().Error("error reading checkpoint", "file", c.resume, "error", err)
+			return 1
+		}
+		defer checkpoint.Close()
+		opts.Checkpoint = checkpoint
+		hclog.L().Info("resuming import", "imported", checkpoint.Imported())
+	}
+
+	err = migrate.Import(client, &data, opts)
 	if err != nil {
 		hclog.L().Error("error importing data", "error", err)
 		return 1
@@ -102,8 +234,56 @@ func (c *importCommand) Run(args []string) int {
 	return 0
 }
 
+// plan prints what the import would do without importing
+func (c *importCommand) plan(client *api.Client, data *migrate.Data, opts *migrate.ImportOptions) int {
+	plan, err := migrate.Plan(client, data, opts)
+	if err != nil {
+		hclog.L().Error("error planning import", "error", err)
+		return 1
+	}
+
+	if c.format == "json" {
+		serialized, err := json.MarshalIndent(plan, "", "   ")
+		if err != nil {
+			hclog.L().Error("error serializing plan", "error", err)
+			return 1
+		}
+		c.ui.Output(string(serialized))
+		return 0
+	}
+
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tKIND\tPARTITION\tNAMESPACE\tNAME\tDETAIL")
+	for _, change := range plan.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			change.Action, change.Kind, change.Partition, change.Namespace, change.Name, change.Detail)
+	}
+	w.Flush()
+
+	fmt.Fprintf(&out, "\n%d to create, %d to update, %d up to date, %d conflicts",
+		plan.Count(migrate.PlanCreate), plan.Count(migrate.PlanUpdate),
+		plan.Count(migrate.PlanNoOp), plan.Count(migrate.PlanConflict))
+	c.ui.Output(out.String())
+	return 0
+}
+
 const importHelp = `
 Usage: consul-migrate import [options]
 
   Imports Consul data from the output of consul-migrate export
+
+  With -dry-run nothing is written. Instead each partition, namespace, ACL
+  policy, role and token is listed with whether the import would create it,
+  update it, leave it alone as it is up to date or run into a conflict.
+  Conflicts which would abort the import are listed as well.
+
+  With -journal every object the import creates or updates is recorded along
+  with its previous state. Pass the journal to consul-migrate rollback to undo
+  the import, including one that failed halfway.
+
+  With -checkpoint the progress of the import is recorded after each object.
+  When the import is interrupted, running it again with -resume and the same
+  data continues where it stopped. A checkpoint recorded for different data or
+  with different -dc-map, -on-conflict or -rename-suffix options is rejected.
 `
@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/mkeeler/consul-migrate/internal/migrate"
+)
+
+type rollbackCommand struct {
+	ui    cli.Ui
+	flags *flag.FlagSet
+	http  *httpFlags
+
+	journal string
+	verbose bool
+	silent  bool
+}
+
+func NewRollback(ui cli.Ui) (cli.Command, error) {
+	c := &rollbackCommand{
+		ui:    ui,
+		http:  &httpFlags{},
+		flags: flag.NewFlagSet("", flag.ContinueOnError),
+	}
+
+	c.flags.BoolVar(&c.silent, "silent", false, "Disables all normal log output")
+	c.flags.BoolVar(&c.verbose, "verbose", false, "Enable verbose debugging output")
+	c.flags.StringVar(&c.journal, "journal", "", "File path of the journal written by consul-migrate import -journal")
+
+	flagMerge(c.flags, c.http.flags())
+	return c, nil
+}
+
+func (c *rollbackCommand) Help() string {
+	return usage(rollbackHelp, c.flags)
+}
+
+func (c *rollbackCommand) Synopsis() string {
+	return "Undo an import recorded in a journal"
+}
+
+func (c *rollbackCommand) Run(args []string) int {
+	if err := c.flags.Parse(args); err != nil {
+		c.ui.Error(fmt.Sprintf("Failed to parse flags: %v", err))
+		return 1
+	}
+
+	if c.verbose && c.silent {
+		c.ui.Error(fmt.Sprintf("Cannot specify both -silent and -verbose"))
+		return 1
+	}
+
+	if c.journal == "" {
+		c.ui.Error("Missing required -journal flag")
+		return 1
+	}
+
+	level := hclog.Info
+	if c.verbose {
+		level = hclog.Debug
+	} else if c.silent {
+		level = hclog.Off
+	}
+
+	initLogging(c.ui, level)
+
+	entries, err := migrate.ReadJournal(c.journal)
+	if err != nil {
+		hclog.L().Error("error reading journal", "file", c.journal, "error", err)
+		return 1
+	}
+
+	client, err := c.http.apiClient()
+	if err != nil {
+		hclog.L().Error("error connecting to Consul agent", "error", err)
+		return 1
+	}
+
+	hclog.L().Info("rolling back import", "changes", len(entries))
+	if err := migrate.Rollback(client, entries); err != nil {
+		hclog.L().Error("error rolling back import", "error", err)
+		return 1
+	}
+
+	hclog.L().Info("successfully rolled back import")
+	return 0
+}
+
+const rollbackHelp = `
+Usage: consul-migrate rollback [options]
+
+  Undoes the changes recorded by consul-migrate import -journal, newest first.
+  Objects the import created are deleted and objects it updated are restored
+  to their previous state. Changes made to those objects after the import are
+  lost.
+`
@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/mkeeler/consul-migrate/internal/migrate"
+)
+
+type snapshotCommand struct {
+	ui    cli.Ui
+	flags *flag.FlagSet
+
+	input      string
+	output     string
+	datacenter string
+	verbose    bool
+	silent     bool
+}
+
+func NewSnapshot(ui cli.Ui) (cli.Command, error) {
+	c := &snapshotCommand{
+		ui:    ui,
+		flags: flag.NewFlagSet("", flag.ContinueOnError),
+	}
+
+	c.flags.BoolVar(&c.silent, "silent", false, "Disables all normal log output")
+	c.flags.BoolVar(&c.verbose, "verbose", false, "Enable verbose debugging output")
+	c.flags.StringVar(&c.input, "input", "", "File path of the snapshot archive. Defaults to stdin")
+	c.flags.StringVar(&c.output, "output", "", "File path to output the data to. "+
+		"The file is only readable by its owner as the data holds token secrets. Defaults to stdout")
+	c.flags.StringVar(&c.datacenter, "datacenter", "", "The datacenter the snapshot was saved in. "+
+		"Snapshots do not record it but import needs it to know where local tokens belong")
+
+	return c, nil
+}
+
+func (c *snapshotCommand) Help() string {
+	return usage(snapshotHelp, c.flags)
+}
+
+func (c *snapshotCommand) Synopsis() string {
+	return "Extract Consul data from a snapshot archive"
+}
+
+func (c *snapshotCommand) Run(args []string) int {
+	if err := c.flags.Parse(args); err != nil {
+		c.ui.Error(fmt.Sprintf("Failed to parse flags: %v", err))
+		return 1
+	}
+
+	if c.verbose && c.silent {
+		c.ui.Error(fmt.Sprintf("Cannot specify both -silent and -verbose"))
+		return 1
+	}
+
+	level := hclog.Info
+	if c.verbose {
+		level = hclog.Debug
+	} else if c.silent {
+		level = hclog.Off
+	}
+
+	initLogging(c.ui, level)
+
+	in := os.Stdin
+	if c.input != "" {
+		f, err := os.Open(c.input)
+		if err != nil {
+			hclog.L().Error("error opening snapshot archive", "error", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	hclog.L().Info("extracting data from snapshot")
+	data, err := migrate.ExtractSnapshot(in)
+	if err != nil {
+		hclog.L().Error("error extracting data from snapshot", "error", err)
+		return 1
+	}
+	data.Datacenter = c.datacenter
+
+	serialized, err := json.MarshalIndent(data, "", "   ")
+	if err != nil {
+		hclog.L().Error("error serializing extracted data", "error", err)
+		return 1
+	}
+
+	if c.output == "" {
+		c.ui.Output(string(serialized))
+	} else {
+		if err := writeSecretFile(c.output, serialized); err != nil {
+			hclog.L().Error("failed to write data to file", "file", c.output, "error", err)
+			return 1
+		}
+		hclog.L().Info("data written to file", "file", c.output)
+	}
+
+	return 0
+}
+
+const snapshotHelp = `
+Usage: consul-migrate snapshot [options]
+
+  Extracts the ACL and KV data from a snapshot archive saved with
+  consul snapshot save. The output can be imported with consul-migrate import.
+  No running Consul is required.
+`
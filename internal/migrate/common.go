@@ -1,7 +1,10 @@
 package migrate
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/hashicorp/consul/api"
@@ -21,3 +24,84 @@ func isEnterprise(client *api.Client) (bool, error) {
 	}
 	return strings.Contains(vers, "+ent"), nil
 }
+
+// supportsConfigEntryKind checks whether the Consul servers know about the given
+// kind of config entry. Older servers reject listing kinds they do not know.
+func supportsConfigEntryKind(client *api.Client, kind string) (bool, error) {
+	_, _, err := client.ConfigEntries().List(kind, nil)
+	if err == nil {
+		return true, nil
+	}
+
+	if isUnsupportedKindError(err) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("error listing %s config entries: %w", kind, err)
+}
+
+// isUnsupportedKindError checks whether a request failed because the Consul servers do
+// not know the kind of config entry. Servers reject writing such an entry with 400 Bad
+// Request while listing or reading it fails with 500 Internal Server Error.
+func isUnsupportedKindError(err error) bool {
+	var statusErr api.StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	if statusErr.Code != http.StatusBadRequest && statusErr.Code != http.StatusInternalServerError {
+		return false
+	}
+	return strings.Contains(statusErr.Body, "invalid config entry kind")
+}
+
+// isACLNotFoundError checks whether reading an ACL object failed because it does not
+// exist. Consul answers with 403 Forbidden for missing ACL objects, so the body tells
+// them apart from requests the token is not allowed to make.
+func isACLNotFoundError(err error) bool {
+	var statusErr api.StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == http.StatusForbidden &&
+		strings.Contains(statusErr.Body, "ACL not found")
+}
+
+// isNotFoundError checks whether a request failed because Consul answered with 404 Not Found
+func isNotFoundError(err error) bool {
+	var statusErr api.StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound
+}
+
+// supportsPartitions checks whether Consul Enterprise has admin partitions. Servers
+// which predate admin partitions do not know the partitions endpoint.
+func supportsPartitions(client *api.Client) (bool, error) {
+	_, _, err := client.Partitions().List(context.Background(), nil)
+	if err == nil {
+		return true, nil
+	}
+
+	if isNotFoundError(err) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("error listing partitions: %w", err)
+}
+
+// agentDatacenters returns the datacenter of the agent along with the primary
+// datacenter of its federation.
+func agentDatacenters(client *api.Client) (string, string, error) {
+	info, err := client.Agent().Self()
+	if err != nil {
+		return "", "", fmt.Errorf("error retrieving Consul info: %w", err)
+	}
+
+	dc, ok := info["Config"]["Datacenter"].(string)
+	if !ok {
+		return "", "", fmt.Errorf("consul info datacenter field is not a string")
+	}
+
+	primary, _ := info["Config"]["PrimaryDatacenter"].(string)
+	if primary == "" {
+		// without a configured primary the datacenter is on its own
+		primary = dc
+	}
+
+	return dc, primary, nil
+}
@@ -0,0 +1,61 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestStatusErrors(t *testing.T) {
+	cases := map[string]struct {
+		err             error
+		aclNotFound     bool
+		unsupportedKind bool
+	}{
+		"acl not found": {
+			err:         api.StatusError{Code: http.StatusForbidden, Body: "ACL not found"},
+			aclNotFound: true,
+		},
+		"wrapped acl not found": {
+			err:         fmt.Errorf("error reading token: %w", api.StatusError{Code: http.StatusForbidden, Body: "ACL not found"}),
+			aclNotFound: true,
+		},
+		"permission denied": {
+			err: api.StatusError{Code: http.StatusForbidden, Body: "Permission denied"},
+		},
+		"acl not found in another status": {
+			err: api.StatusError{Code: http.StatusInternalServerError, Body: "rpc error: ACL not found"},
+		},
+		"acl not found without status": {
+			err: errors.New("ACL not found"),
+		},
+		"unknown kind written": {
+			err:             api.StatusError{Code: http.StatusBadRequest, Body: "Request decoding failed: invalid config entry kind: jwt-provider"},
+			unsupportedKind: true,
+		},
+		"unknown kind listed": {
+			err:             fmt.Errorf("error listing: %w", api.StatusError{Code: http.StatusInternalServerError, Body: "invalid config entry kind: jwt-provider"}),
+			unsupportedKind: true,
+		},
+		"unknown kind without status": {
+			err: errors.New("invalid config entry kind: jwt-provider"),
+		},
+		"not found": {
+			err: api.StatusError{Code: http.StatusNotFound, Body: "invalid config entry kind"},
+		},
+	}
+
+	for name, tcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := isACLNotFoundError(tcase.err); got != tcase.aclNotFound {
+				t.Fatalf("expected isACLNotFoundError to be %v, got %v", tcase.aclNotFound, got)
+			}
+			if got := isUnsupportedKindError(tcase.err); got != tcase.unsupportedKind {
+				t.Fatalf("expected isUnsupportedKindError to be %v, got %v", tcase.unsupportedKind, got)
+			}
+		})
+	}
+}
@@ -1,16 +1,60 @@
 package migrate
 
-import "github.com/hashicorp/consul/api"
+import (
+	"encoding/json"
+
+	"github.com/hashicorp/consul/api"
+)
 
 type Data struct {
-	Enterprise bool                     `json:"enterprise,omitempty"`
+	Enterprise bool `json:"enterprise,omitempty"`
+	// Datacenter is the datacenter the data was exported from. Local tokens
+	// only exist within this datacenter.
+	Datacenter string `json:"datacenter,omitempty"`
+	// Namespaces are the namespaces of the default partition
+	Namespaces map[string]NamespaceData `json:"namespaces,omitempty"`
+	// Partitions are all the admin partitions other than the default partition
+	Partitions map[string]PartitionData `json:"partitions,omitempty"`
+	ScopedData
+	// Intentions are the intentions from a source which still stores them
+	// through the legacy intentions API. Sources that support service-intentions
+	// config entries export them as config entries instead.
+	Intentions []api.Intention `json:"intentions,omitempty"`
+	// PreparedQueries is the list of all prepared queries including templates
+	PreparedQueries []api.PreparedQueryDefinition `json:"prepared_queries,omitempty"`
+	// Catalog is the list of nodes registered directly through the catalog
+	// rather than by a Consul agent
+	Catalog []CatalogNode `json:"catalog,omitempty"`
+	// ConnectCA is the Connect CA configuration. As it holds private keys
+	// it is only exported when secrets are requested.
+	ConnectCA *api.CAConfig `json:"connect_ca,omitempty"`
+	// Operator holds the operator level settings of the datacenter
+	Operator *OperatorData `json:"operator,omitempty"`
+	// Keyring holds the gossip encryption keys. As they allow joining the gossip
+	// pools they are only exported when secrets are requested.
+	Keyring *KeyringData `json:"keyring,omitempty"`
+}
+
+type PartitionData struct {
+	Definition api.Partition            `json:"definition"`
 	Namespaces map[string]NamespaceData `json:"namespaces,omitempty"`
-	ACLData
 }
 
 type NamespaceData struct {
 	Definition api.Namespace `json:"definition"`
+	ScopedData
+}
+
+// ScopedData is all the data that lives within a single namespace. For
+// OSS this is everything in the datacenter.
+type ScopedData struct {
 	ACLData
+	// KV is the list of all key/value entries. Values remain base64 encoded
+	// when serialized.
+	KV api.KVPairs `json:"kv,omitempty"`
+	// ConfigEntries is the list of all config entries. Kinds unknown to
+	// consul-migrate are kept as raw JSON.
+	ConfigEntries ConfigEntries `json:"config_entries,omitempty"`
 }
 
 type ACLData struct {
@@ -20,4 +64,183 @@ type ACLData struct {
 	ACLRoles map[string]api.ACLRole `json:"acl_roles,omitempty"`
 	// ACLTokens is a map of accessor id to acl token definition
 	ACLTokens map[string]api.ACLToken `json:"acl_tokens,omitempty"`
+	// ACLAuthMethods is a mapping of the auth method name to the auth method definition
+	ACLAuthMethods map[string]api.ACLAuthMethod `json:"acl_auth_methods,omitempty"`
+	// ACLBindingRules is a mapping of the binding rule id to the binding rule definition
+	ACLBindingRules map[string]api.ACLBindingRule `json:"acl_binding_rules,omitempty"`
+}
+
+type OperatorData struct {
+	// Autopilot is the autopilot configuration of the servers
+	Autopilot *api.AutopilotConfiguration `json:"autopilot,omitempty"`
+	// NetworkAreas are the Consul Enterprise network areas along with
+	// the addresses used to join them
+	NetworkAreas []api.Area `json:"network_areas,omitempty"`
+}
+
+type KeyringData struct {
+	// Keys are all the keys installed in the LAN gossip pool
+	Keys []string `json:"keys"`
+	// PrimaryKey is the key used to encrypt gossip messages. It is empty
+	// when the source does not report its primary key.
+	PrimaryKey string `json:"primary_key,omitempty"`
+}
+
+// CatalogNode is a node along with all of its services and checks
+type CatalogNode struct {
+	Node     api.Node           `json:"node"`
+	Services []api.AgentService `json:"services,omitempty"`
+	Checks   []api.HealthCheck  `json:"checks,omitempty"`
+}
+
+// scopes returns the scoped data of every namespace within the data
+func (d *Data) scopes() []*ScopedData {
+	if !d.Enterprise {
+		return []*ScopedData{&d.ScopedData}
+	}
+
+	var scopes []*ScopedData
+	for name := range d.Namespaces {
+		ns := d.Namespaces[name]
+		scopes = append(scopes, &ns.ScopedData)
+	}
+	for _, partition := range d.Partitions {
+		for name := range partition.Namespaces {
+			ns := partition.Namespaces[name]
+			scopes = append(scopes, &ns.ScopedData)
+		}
+	}
+	return scopes
+}
+
+// configEntryKind is a kind of config entry known to consul-migrate
+type configEntryKind struct {
+	name string
+	// order is the position of the kind among the kinds to write. Consul validates
+	// discovery chain entries against the entries they depend on: resolvers need the
+	// protocol from the defaults, splitters need the subsets from the resolvers and
+	// routers need the splits to point at. Gateways go after them as their listeners
+	// are validated against the protocols of the services, API gateways after the
+	// certificates they use and routes after the gateways they attach to.
+	order int
+	// typed kinds are decoded into their api types. The api library used by
+	// consul-migrate has no types for the others, so they are kept as raw JSON.
+	typed bool
+	// global kinds always live in the default namespace regardless of the namespace
+	// they are queried from
+	global bool
+}
+
+// configEntryKinds are the kinds of config entries that get exported. Kinds added
+// to Consul after these are only exported when requested through
+// ExportOptions.ConfigEntryKinds as Consul has no way to list the kinds it knows.
+var configEntryKinds = []configEntryKind{
+	{name: api.ProxyDefaults, order: 0, typed: true, global: true},
+	{name: api.MeshConfig, order: 0, typed: true, global: true},
+	{name: api.ServiceDefaults, order: 1, typed: true},
+	{name: api.ServiceResolver, order: 2, typed: true},
+	{name: api.ServiceSplitter, order: 3, typed: true},
+	{name: api.ServiceRouter, order: 4, typed: true},
+	{name: api.IngressGateway, order: 5, typed: true},
+	{name: api.TerminatingGateway, order: 5, typed: true},
+	{name: api.ServiceIntentions, order: 6, typed: true},
+	{name: api.ExportedServices, order: 7, typed: true, global: true},
+	// the api library has no constants for the kinds below
+	{name: "sameness-group", order: 7},
+	{name: "jwt-provider", order: 7},
+	{name: "control-plane-request-limit", order: 7},
+	{name: "inline-certificate", order: 7},
+	{name: "file-system-certificate", order: 7},
+	{name: "api-gateway", order: 8},
+	{name: "http-route", order: 9},
+	{name: "tcp-route", order: 9},
+}
+
+// configEntryKindsVersion is the newest Consul version whose config entry kinds are
+// all within configEntryKinds
+const configEntryKindsVersion = "1.19"
+
+// knownConfigEntryKinds indexes configEntryKinds by name
+var knownConfigEntryKinds = func() map[string]configEntryKind {
+	kinds := make(map[string]configEntryKind, len(configEntryKinds))
+	for _, kind := range configEntryKinds {
+		kinds[kind.name] = kind
+	}
+	return kinds
+}()
+
+// ConfigEntries is a list of config entries of varying kinds. It exists to
+// decode each entry into the concrete type for its kind. Entries of kinds
+// without a concrete type are kept as RawConfigEntry.
+type ConfigEntries []api.ConfigEntry
+
+func (c *ConfigEntries) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	entries := make(ConfigEntries, 0, len(raw))
+	for _, rawEntry := range raw {
+		entry, err := decodeConfigEntry(rawEntry)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	*c = entries
+	return nil
+}
+
+// decodeConfigEntry decodes a single config entry into the concrete type for its
+// kind or into a RawConfigEntry for kinds without one
+func decodeConfigEntry(data []byte) (api.ConfigEntry, error) {
+	var generic RawConfigEntry
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+
+	if !knownConfigEntryKinds[generic.GetKind()].typed {
+		return generic, nil
+	}
+
+	return api.DecodeConfigEntryFromJSON(data)
+}
+
+// RawConfigEntry is a config entry of a kind that consul-migrate has no concrete
+// type for. It holds the JSON representation of the entry as is so that newer
+// kinds can still be migrated.
+type RawConfigEntry map[string]interface{}
+
+func (r RawConfigEntry) GetKind() string      { return r.getString("Kind") }
+func (r RawConfigEntry) GetName() string      { return r.getString("Name") }
+func (r RawConfigEntry) GetPartition() string { return r.getString("Partition") }
+func (r RawConfigEntry) GetNamespace() string { return r.getString("Namespace") }
+
+func (r RawConfigEntry) GetMeta() map[string]string {
+	raw, _ := r["Meta"].(map[string]interface{})
+	if raw == nil {
+		return nil
+	}
+
+	meta := make(map[string]string, len(raw))
+	for k, v := range raw {
+		meta[k], _ = v.(string)
+	}
+	return meta
+}
+
+func (r RawConfigEntry) GetCreateIndex() uint64 { return r.getUint("CreateIndex") }
+func (r RawConfigEntry) GetModifyIndex() uint64 { return r.getUint("ModifyIndex") }
+
+func (r RawConfigEntry) getString(key string) string {
+	v, _ := r[key].(string)
+	return v
+}
+
+func (r RawConfigEntry) getUint(key string) uint64 {
+	// numbers decoded from JSON are always floats
+	v, _ := r[key].(float64)
+	return uint64(v)
 }
@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestDecodeConfigEntry(t *testing.T) {
+	cases := map[string]struct {
+		data string
+		raw  bool
+	}{
+		"mesh": {
+			data: `{"Kind":"mesh","Name":"mesh","TransparentProxy":{"MeshDestinationsOnly":true}}`,
+		},
+		"exported-services": {
+			data: `{"Kind":"exported-services","Name":"default","Services":[{"Name":"web","Consumers":[{"Partition":"other"}]}]}`,
+		},
+		"api-gateway": {
+			data: `{"Kind":"api-gateway","Name":"gateway","Listeners":[]}`,
+			raw:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			entry, err := decodeConfigEntry([]byte(tc.data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, isRaw := entry.(RawConfigEntry)
+			if isRaw != tc.raw {
+				t.Fatalf("expected the entry to be raw: %v, got %#v", tc.raw, entry)
+			}
+			if entry.GetKind() != name {
+				t.Fatalf("expected kind %s, got %s", name, entry.GetKind())
+			}
+		})
+	}
+
+	entry, err := decodeConfigEntry([]byte(cases["mesh"].data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh, ok := entry.(*api.MeshConfigEntry)
+	if !ok || !mesh.TransparentProxy.MeshDestinationsOnly {
+		t.Fatalf("expected the mesh settings to be decoded, got %#v", entry)
+	}
+}
@@ -1,38 +1,141 @@
 package migrate
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 )
 
-func Export(client *api.Client) (*Data, error) {
-	if ent, err := isEnterprise(client); err != nil {
+// ExportOptions controls which data gets exported
+type ExportOptions struct {
+	// IncludeSecrets enables exporting secret data that is not needed to migrate
+	// the ACL system, such as the Connect CA provider configuration which holds
+	// private keys and the gossip encryption keys.
+	IncludeSecrets bool
+	// ConfigEntryKinds are additional kinds of config entries to export as raw
+	// JSON. It is meant for kinds which are newer than consul-migrate.
+	ConfigEntryKinds []string
+}
+
+func Export(client *api.Client, exportOpts *ExportOptions) (*Data, error) {
+	if exportOpts == nil {
+		exportOpts = &ExportOptions{}
+	}
+
+	ent, err := isEnterprise(client)
+	if err != nil {
 		return nil, fmt.Errorf("error determining whether Consul is OSS or Enterprise: %w", err)
-	} else if ent {
+	}
+
+	if err := warnNewerConfigEntryKinds(client); err != nil {
+		return nil, err
+	}
+
+	var data *Data
+	// allOpts are the query options to retrieve data from all namespaces at once
+	var allOpts *api.QueryOptions
+	if ent {
 		hclog.L().Debug("exporting data from Consul Enterprise")
-		return exportEnterprise(client)
+		data, err = exportEnterprise(client, exportOpts)
+		allOpts = &api.QueryOptions{Namespace: "*"}
 	} else {
 		hclog.L().Debug("exporting data from Consul OSS")
-		return exportOSS(client)
+		data, err = exportOSS(client, exportOpts)
+	}
+	if err != nil {
+		return nil, err
 	}
-}
 
-func exportEnterprise(client *api.Client) (*Data, error) {
-	ns := client.Namespaces()
+	if err := exportGlobalData(client, data, allOpts); err != nil {
+		return nil, err
+	}
 
-	hclog.L().Debug("gathering namespace list")
-	nsList, _, err := ns.List(nil)
+	data.Datacenter, _, err = agentDatacenters(client)
 	if err != nil {
-		return nil, fmt.Errorf("error listing namespaces: %w", err)
+		return nil, err
+	}
+
+	if exportOpts.IncludeSecrets {
+		if err := exportSecretData(client, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+func exportEnterprise(client *api.Client, exportOpts *ExportOptions) (*Data, error) {
+	namespaces, err := exportNamespaces(client, "", exportOpts)
+	if err != nil {
+		return nil, err
 	}
 
 	data := &Data{
 		Enterprise: true,
-		Namespaces: make(map[string]NamespaceData),
+		Namespaces: namespaces,
+	}
+
+	partitioned, err := supportsPartitions(client)
+	if err != nil {
+		return nil, err
+	}
+
+	if !partitioned {
+		return data, nil
 	}
 
+	hclog.L().Debug("gathering partition list")
+	partitionList, _, err := client.Partitions().List(context.Background(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing partitions: %w", err)
+	}
+
+	for _, partition := range partitionList {
+		// the default partition holds the top level namespaces
+		if partition.Name == api.PartitionDefaultName {
+			continue
+		}
+
+		// ignore deleted partitions
+		if partition.DeletedAt != nil && !partition.DeletedAt.IsZero() {
+			hclog.L().Debug("ignoring deleted partition", "partition", partition.Name)
+			continue
+		}
+
+		namespaces, err := exportNamespaces(client, partition.Name, exportOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error exporting data for partition %s: %w", partition.Name, err)
+		}
+
+		if data.Partitions == nil {
+			data.Partitions = make(map[string]PartitionData)
+		}
+
+		data.Partitions[partition.Name] = PartitionData{
+			Definition: *partition,
+			Namespaces: namespaces,
+		}
+	}
+
+	return data, nil
+}
+
+// exportNamespaces exports the data of every namespace within a partition. An
+// empty partition refers to the default partition.
+func exportNamespaces(client *api.Client, partition string, exportOpts *ExportOptions) (map[string]NamespaceData, error) {
+	hclog.L().Debug("gathering namespace list", "partition", partition)
+	nsList, _, err := client.Namespaces().List(&api.QueryOptions{Partition: partition})
+	if err != nil {
+		return nil, fmt.Errorf("error listing namespaces: %w", err)
+	}
+
+	namespaces := make(map[string]NamespaceData)
 	for _, ns := range nsList {
 		// ignore deleted namespaces
 		if ns.DeletedAt != nil && !ns.DeletedAt.IsZero() {
@@ -41,32 +144,118 @@ func exportEnterprise(client *api.Client) (*Data, error) {
 		}
 
 		opts := api.QueryOptions{
+			Partition: partition,
 			Namespace: ns.Name,
 		}
 
-		hclog.L().Debug("exporting ACL data for namespace", "ns", ns.Name)
-		aclData, err := exportACLData(client, &opts)
+		scoped, err := exportScopedData(client, &opts, exportOpts)
 		if err != nil {
-			return nil, fmt.Errorf("error exporting acl data for namespace %s: %w", ns.Name, err)
+			return nil, fmt.Errorf("error exporting data for namespace %s: %w", ns.Name, err)
 		}
 
-		data.Namespaces[ns.Name] = NamespaceData{
+		namespaces[ns.Name] = NamespaceData{
 			Definition: *ns,
-			ACLData:    *aclData,
+			ScopedData: *scoped,
 		}
 	}
 
-	return data, nil
+	return namespaces, nil
 }
 
-func exportOSS(client *api.Client) (*Data, error) {
-	hclog.L().Debug("exporting ACL data")
-	aclData, err := exportACLData(client, nil)
+func exportOSS(client *api.Client, exportOpts *ExportOptions) (*Data, error) {
+	scoped, err := exportScopedData(client, nil, exportOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Data{ACLData: *aclData}, nil
+	return &Data{ScopedData: *scoped}, nil
+}
+
+// exportGlobalData exports the data which is not scoped to a single namespace.
+func exportGlobalData(client *api.Client, data *Data, opts *api.QueryOptions) error {
+	intentions, err := exportIntentions(client, opts)
+	if err != nil {
+		return err
+	}
+
+	queries, err := exportPreparedQueries(client)
+	if err != nil {
+		return err
+	}
+
+	partitions := make([]string, 0, len(data.Partitions))
+	for partition := range data.Partitions {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+
+	catalog, err := exportCatalog(client, opts, partitions)
+	if err != nil {
+		return err
+	}
+
+	operator, err := exportOperatorData(client, data.Enterprise)
+	if err != nil {
+		return err
+	}
+
+	data.Intentions = intentions
+	data.PreparedQueries = queries
+	data.Catalog = catalog
+	data.Operator = operator
+	return nil
+}
+
+func exportOperatorData(client *api.Client, enterprise bool) (*OperatorData, error) {
+	hclog.L().Debug("exporting autopilot configuration")
+	autopilot, err := client.Operator().AutopilotGetConfiguration(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading autopilot configuration: %w", err)
+	}
+
+	operator := &OperatorData{Autopilot: autopilot}
+	if !enterprise {
+		return operator, nil
+	}
+
+	hclog.L().Debug("exporting network areas")
+	areaList, _, err := client.Operator().AreaList(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing network areas: %w", err)
+	}
+
+	for _, area := range areaList {
+		operator.NetworkAreas = append(operator.NetworkAreas, *area)
+	}
+
+	return operator, nil
+}
+
+func exportScopedData(client *api.Client, opts *api.QueryOptions, exportOpts *ExportOptions) (*ScopedData, error) {
+	logger := hclog.L()
+	if opts != nil {
+		logger = logger.With("partition", opts.Partition, "ns", opts.Namespace)
+	}
+
+	logger.Debug("exporting ACL data")
+	aclData, err := exportACLData(client, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting acl data: %w", err)
+	}
+
+	logger.Debug("exporting KV data")
+	kv, err := exportKV(client, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting kv data: %w", err)
+	}
+
+	logger.Debug("exporting config entries")
+	entries, err := exportConfigEntries(client, opts, exportOpts.ConfigEntryKinds)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting config entries: %w", err)
+	}
+
+	return &ScopedData{ACLData: *aclData, KV: kv, ConfigEntries: entries}, nil
 }
 
 func exportACLData(client *api.Client, opts *api.QueryOptions) (*ACLData, error) {
@@ -85,7 +274,27 @@ func exportACLData(client *api.Client, opts *api.QueryOptions) (*ACLData, error)
 		return nil, fmt.Errorf("failed to export acl tokens: %w", err)
 	}
 
-	return &ACLData{ACLPolicies: policies, ACLRoles: roles, ACLTokens: tokens}, nil
+	if err := translateLegacyACLTokens(client, policies, tokens); err != nil {
+		return nil, fmt.Errorf("failed to translate legacy acl tokens: %w", err)
+	}
+
+	authMethods, err := exportACLAuthMethods(client, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export acl auth methods: %w", err)
+	}
+
+	bindingRules, err := exportACLBindingRules(client, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export acl binding rules: %w", err)
+	}
+
+	return &ACLData{
+		ACLPolicies:     policies,
+		ACLRoles:        roles,
+		ACLTokens:       tokens,
+		ACLAuthMethods:  authMethods,
+		ACLBindingRules: bindingRules,
+	}, nil
 }
 
 func exportACLPolicies(client *api.Client, opts *api.QueryOptions) (map[string]api.ACLPolicy, error) {
@@ -155,3 +364,417 @@ func exportACLTokens(client *api.Client, opts *api.QueryOptions) (map[string]api
 
 	return tokens, nil
 }
+
+// translateLegacyACLTokens converts legacy tokens, which carry their rules on the token
+// itself, into tokens of the new ACL system. Rules are translated into the current
+// syntax by the source and one policy is synthesized per distinct set of translated
+// rules. The tokens keep their secrets so that existing clients keep working.
+func translateLegacyACLTokens(client *api.Client, policies map[string]api.ACLPolicy, tokens map[string]api.ACLToken) error {
+	// translated caches the translation of every distinct set of legacy rules
+	translated := make(map[string]string)
+
+	for accessorID, token := range tokens {
+		if token.Rules == "" {
+			continue
+		}
+
+		rules, ok := translated[token.Rules]
+		if !ok {
+			var err error
+			rules, err = client.ACL().RulesTranslate(strings.NewReader(token.Rules))
+			if err != nil {
+				return fmt.Errorf("error translating rules of token %s: %w", accessorID, err)
+			}
+			translated[token.Rules] = rules
+		}
+
+		sum := sha256.Sum256([]byte(rules))
+		// the name doubles as the policy id within the exported data as the
+		// synthesized policy has no id until it is created on the target
+		name := "legacy-rules-" + hex.EncodeToString(sum[:])[:16]
+
+		if _, ok := policies[name]; !ok {
+			policies[name] = api.ACLPolicy{
+				ID:          name,
+				Name:        name,
+				Description: "Rules translated from legacy ACL tokens",
+				Rules:       rules,
+			}
+			hclog.L().Info("synthesized policy from legacy token rules", "policy", name)
+		}
+
+		token.Rules = ""
+		token.Policies = append(token.Policies, &api.ACLTokenPolicyLink{ID: name, Name: name})
+		tokens[accessorID] = token
+
+		hclog.L().Info("translated legacy ACL token", "accessor-id", accessorID, "policy", name)
+	}
+
+	return nil
+}
+
+func exportACLAuthMethods(client *api.Client, opts *api.QueryOptions) (map[string]api.ACLAuthMethod, error) {
+	acls := client.ACL()
+
+	methodList, _, err := acls.AuthMethodList(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error listing auth methods: %w", err)
+	}
+
+	methods := make(map[string]api.ACLAuthMethod)
+	for _, methodStub := range methodList {
+		method, _, err := acls.AuthMethodRead(methodStub.Name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error reading auth method: %w", err)
+		}
+
+		methods[method.Name] = *method
+	}
+
+	return methods, nil
+}
+
+func exportACLBindingRules(client *api.Client, opts *api.QueryOptions) (map[string]api.ACLBindingRule, error) {
+	ruleList, _, err := client.ACL().BindingRuleList("", opts)
+	if err != nil {
+		return nil, fmt.Errorf("error listing binding rules: %w", err)
+	}
+
+	rules := make(map[string]api.ACLBindingRule)
+	for _, rule := range ruleList {
+		rules[rule.ID] = *rule
+	}
+
+	return rules, nil
+}
+
+func exportKV(client *api.Client, opts *api.QueryOptions) (api.KVPairs, error) {
+	pairs, _, err := client.KV().List("", opts)
+	if err != nil {
+		return nil, fmt.Errorf("error listing kv entries: %w", err)
+	}
+
+	return pairs, nil
+}
+
+// warnNewerConfigEntryKinds warns when the source runs a Consul version which may know
+// config entry kinds that consul-migrate does not. Consul has no way to list the kinds it
+// knows, so entries of those kinds are only exported when their kinds are requested.
+func warnNewerConfigEntryKinds(client *api.Client) error {
+	info, err := client.Agent().Self()
+	if err != nil {
+		return fmt.Errorf("error retrieving Consul info: %w", err)
+	}
+
+	version, _ := info["Config"]["Version"].(string)
+	if newerMinorVersion(version, configEntryKindsVersion) {
+		hclog.L().Warn("the source may have config entries of kinds newer than consul-migrate, "+
+			"these are only exported when their kinds are requested", "version", version, "known-version", configEntryKindsVersion)
+	}
+	return nil
+}
+
+// newerMinorVersion reports whether a Consul version is of a newer major or minor
+// version than the other. Versions which cannot be parsed are not newer.
+func newerMinorVersion(version, than string) bool {
+	var major, minor, thanMajor, thanMinor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+		return false
+	}
+	if _, err := fmt.Sscanf(than, "%d.%d", &thanMajor, &thanMinor); err != nil {
+		return false
+	}
+	return major > thanMajor || (major == thanMajor && minor > thanMinor)
+}
+
+func exportConfigEntries(client *api.Client, opts *api.QueryOptions, extraKinds []string) (ConfigEntries, error) {
+	defaultNS := opts == nil || opts.Namespace == "" || opts.Namespace == "default"
+
+	logger := hclog.L()
+	if opts != nil {
+		logger = logger.With("partition", opts.Partition, "ns", opts.Namespace)
+	}
+
+	kinds := append([]configEntryKind{}, configEntryKinds...)
+	for _, kind := range extraKinds {
+		if _, ok := knownConfigEntryKinds[kind]; !ok {
+			kinds = append(kinds, configEntryKind{name: kind})
+		}
+	}
+
+	var entries ConfigEntries
+	seen := make(map[string]bool)
+	for _, kind := range kinds {
+		if seen[kind.name] || (kind.global && !defaultNS) {
+			continue
+		}
+		seen[kind.name] = true
+
+		var kindEntries ConfigEntries
+		var err error
+		if kind.typed {
+			kindEntries, _, err = client.ConfigEntries().List(kind.name, opts)
+		} else {
+			kindEntries, err = exportRawConfigEntries(client, kind.name, opts)
+		}
+		if err != nil && isUnsupportedKindError(err) {
+			logger.Debug("skipping config entry kind not supported by the source", "kind", kind.name)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error listing %s config entries: %w", kind.name, err)
+		}
+
+		if !kind.typed && len(kindEntries) > 0 {
+			logger.Warn("exporting config entries of a kind unknown to consul-migrate as raw JSON",
+				"kind", kind.name, "entries", len(kindEntries))
+		}
+		entries = append(entries, kindEntries...)
+	}
+
+	return entries, nil
+}
+
+// exportRawConfigEntries lists the config entries of a kind through the raw config
+// entry endpoint. Kinds which live in the default namespace may be returned for
+// any namespace, so only the entries of the queried namespace are kept.
+func exportRawConfigEntries(client *api.Client, kind string, opts *api.QueryOptions) (ConfigEntries, error) {
+	var rawEntries []RawConfigEntry
+	if _, err := client.Raw().Query("/v1/config/"+kind, &rawEntries, opts); err != nil {
+		return nil, err
+	}
+
+	var entries ConfigEntries
+	for _, entry := range rawEntries {
+		if ns := entry.GetNamespace(); ns != "" && opts != nil && opts.Namespace != "" && ns != opts.Namespace {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// exportIntentions exports intentions through the legacy intentions API but only when
+// the source is too old to store them as service-intentions config entries. Newer
+// sources have their intentions exported along with the other config entries.
+func exportIntentions(client *api.Client, opts *api.QueryOptions) ([]api.Intention, error) {
+	supported, err := supportsConfigEntryKind(client, api.ServiceIntentions)
+	if err != nil {
+		return nil, err
+	}
+
+	if supported {
+		return nil, nil
+	}
+
+	hclog.L().Debug("exporting legacy intentions")
+	intentionList, _, err := client.Connect().Intentions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error listing intentions: %w", err)
+	}
+
+	intentions := make([]api.Intention, 0, len(intentionList))
+	for _, intention := range intentionList {
+		intentions = append(intentions, *intention)
+	}
+
+	return intentions, nil
+}
+
+func exportPreparedQueries(client *api.Client) ([]api.PreparedQueryDefinition, error) {
+	hclog.L().Debug("exporting prepared queries")
+	queryList, _, err := client.PreparedQuery().List(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing prepared queries: %w", err)
+	}
+
+	queries := make([]api.PreparedQueryDefinition, 0, len(queryList))
+	for _, query := range queryList {
+		if query.Token == hiddenToken {
+			hclog.L().Warn("prepared query token is hidden from the exporting token and will not be migrated", "query", query.Name)
+		}
+		queries = append(queries, *query)
+	}
+
+	return queries, nil
+}
+
+// exportSecretData exports the data holding private key material. This is only
+// done when secrets were explicitly requested.
+func exportSecretData(client *api.Client, data *Data) error {
+	caConfig, err := exportConnectCA(client)
+	if err != nil {
+		return err
+	}
+
+	keyring, err := exportKeyring(client)
+	if err != nil {
+		return err
+	}
+
+	data.ConnectCA = caConfig
+	data.Keyring = keyring
+	return nil
+}
+
+// exportKeyring exports the gossip encryption keys of the LAN gossip pool
+func exportKeyring(client *api.Client) (*KeyringData, error) {
+	hclog.L().Debug("exporting gossip encryption keyring")
+	rings, err := client.Operator().KeyringList(nil)
+	if err != nil && strings.Contains(err.Error(), "encryption not enabled") {
+		hclog.L().Debug("skipping gossip encryption keyring as gossip encryption is disabled")
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error listing gossip encryption keys: %w", err)
+	}
+
+	ring := lanKeyring(rings)
+	if ring == nil {
+		return nil, nil
+	}
+
+	keyring := &KeyringData{}
+	for key := range ring.Keys {
+		keyring.Keys = append(keyring.Keys, key)
+	}
+	sort.Strings(keyring.Keys)
+
+	primary, primaryNodes := primaryKey(ring)
+	keyring.PrimaryKey = primary
+
+	if len(ring.PrimaryKeys) > 1 {
+		hclog.L().Warn("the nodes use different primary gossip encryption keys, exporting the most used one", "nodes", primaryNodes, "total-nodes", ring.NumNodes)
+	} else if keyring.PrimaryKey == "" {
+		hclog.L().Warn("the source does not report its primary gossip encryption key, the primary key of the target will not be changed")
+	}
+
+	return keyring, nil
+}
+
+// lanKeyring returns the keyring of the LAN gossip pool of the default partition
+func lanKeyring(rings []*api.KeyringResponse) *api.KeyringResponse {
+	for _, ring := range rings {
+		if ring.WAN || ring.Segment != "" || (ring.Partition != "" && ring.Partition != api.PartitionDefaultName) {
+			continue
+		}
+		return ring
+	}
+
+	return nil
+}
+
+// primaryKey returns the primary key of a keyring along with the number of nodes using it.
+// The primary key is the one used by most nodes, there is more than one while the primary
+// key is being rotated.
+func primaryKey(ring *api.KeyringResponse) (string, int) {
+	key, nodes := "", 0
+	for candidate, count := range ring.PrimaryKeys {
+		if count > nodes {
+			key, nodes = candidate, count
+		}
+	}
+	return key, nodes
+}
+
+func exportConnectCA(client *api.Client) (*api.CAConfig, error) {
+	hclog.L().Debug("exporting Connect CA configuration")
+	config, _, err := client.Connect().CAGetConfig(nil)
+	if err != nil && strings.Contains(err.Error(), "Connect must be enabled") {
+		hclog.L().Debug("skipping Connect CA configuration as Connect is disabled")
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading Connect CA configuration: %w", err)
+	}
+
+	if _, ok := config.Config["PrivateKey"]; config.Provider == "consul" && !ok {
+		hclog.L().Warn("the built-in Connect CA generated its own private key which cannot be exported, the target will generate a new root certificate")
+	}
+
+	return config, nil
+}
+
+// serfHealthCheckID is the check every Consul agent registers for its own node
+const serfHealthCheckID = "serfHealth"
+
+// exportCatalog exports the nodes which were registered directly through the catalog
+// API along with their services and checks. Nodes with a serf health check belong to
+// a Consul agent which will register them again on its own.
+//
+// Only the nodes of the default partition are exported as the data holds the catalog
+// outside of any partition. The nodes of the other partitions are skipped with a
+// warning for each partition which has any.
+func exportCatalog(client *api.Client, opts *api.QueryOptions, partitions []string) ([]CatalogNode, error) {
+	hclog.L().Debug("exporting catalog registrations")
+	nodes, err := exportCatalogNodes(client, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, partition := range partitions {
+		partitionOpts := &api.QueryOptions{Partition: partition}
+		if opts != nil {
+			partitionOpts.Namespace = opts.Namespace
+		}
+
+		skipped, err := exportCatalogNodes(client, partitionOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error exporting catalog of partition %s: %w", partition, err)
+		}
+		if len(skipped) > 0 {
+			hclog.L().Warn("skipping catalog registrations outside of the default partition", "partition", partition, "nodes", len(skipped))
+		}
+	}
+
+	return nodes, nil
+}
+
+// exportCatalogNodes exports the externally registered nodes of the partition of the
+// query options
+func exportCatalogNodes(client *api.Client, opts *api.QueryOptions) ([]CatalogNode, error) {
+	var nodeOpts *api.QueryOptions
+	if opts != nil && opts.Partition != "" {
+		nodeOpts = &api.QueryOptions{Partition: opts.Partition}
+	}
+
+	nodeList, _, err := client.Catalog().Nodes(nodeOpts)
+	if err != nil {
+		return nil, fmt.Errorf("error listing nodes: %w", err)
+	}
+
+	var nodes []CatalogNode
+	for _, node := range nodeList {
+		checks, _, err := client.Health().Node(node.Node, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error reading checks for node %s: %w", node.Node, err)
+		}
+
+		agentNode := false
+		for _, check := range checks {
+			if check.CheckID == serfHealthCheckID {
+				agentNode = true
+				break
+			}
+		}
+		if agentNode {
+			continue
+		}
+
+		services, _, err := client.Catalog().NodeServiceList(node.Node, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error reading services for node %s: %w", node.Node, err)
+		}
+
+		catalogNode := CatalogNode{Node: *node}
+		for _, service := range services.Services {
+			catalogNode.Services = append(catalogNode.Services, *service)
+		}
+		for _, check := range checks {
+			catalogNode.Checks = append(catalogNode.Checks, *check)
+		}
+
+		hclog.L().Debug("exporting externally registered node", "node", node.Node, "partition", node.Partition, "services", len(catalogNode.Services))
+		nodes = append(nodes, catalogNode)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,283 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestExportConfigEntries(t *testing.T) {
+	listed := map[string][]map[string]interface{}{
+		api.ServiceDefaults: {{"Kind": api.ServiceDefaults, "Name": "web", "Protocol": "http"}},
+		"api-gateway":       {{"Kind": "api-gateway", "Name": "gateway"}},
+		"my-new-kind":       {{"Kind": "my-new-kind", "Name": "new"}, {"Kind": "my-new-kind", "Name": "newer"}},
+	}
+
+	var queried []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kind := strings.TrimPrefix(r.URL.Path, "/v1/config/")
+		queried = append(queried, kind)
+		entries, ok := listed[kind]
+		if !ok && kind == "tcp-route" {
+			http.Error(w, "invalid config entry kind: "+kind, http.StatusBadRequest)
+			return
+		}
+		if entries == nil {
+			entries = []map[string]interface{}{}
+		}
+		json.NewEncoder(w).Encode(entries)
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	previous := hclog.Default()
+	hclog.SetDefault(hclog.New(&hclog.LoggerOptions{Output: &out}))
+	defer hclog.SetDefault(previous)
+
+	entries, err := exportConfigEntries(client, nil, []string{"my-new-kind", api.ServiceDefaults, "my-new-kind"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		_, isRaw := entry.(RawConfigEntry)
+		names = append(names, entry.GetKind()+"/"+entry.GetName())
+		if isRaw == knownConfigEntryKinds[entry.GetKind()].typed {
+			t.Fatalf("expected only the entries of typed kinds to be decoded, got %#v", entry)
+		}
+	}
+	expected := "service-defaults/web api-gateway/gateway my-new-kind/new my-new-kind/newer"
+	if strings.Join(names, " ") != expected {
+		t.Fatalf("expected %s, got %v", expected, names)
+	}
+
+	if len(queried) != len(configEntryKinds)+1 {
+		t.Fatalf("expected each kind to be listed once, got %v", queried)
+	}
+	for _, kind := range []string{"api-gateway", "my-new-kind"} {
+		if strings.Count(out.String(), "kind="+kind) != 1 {
+			t.Fatalf("expected one warning for %s, got %s", kind, out.String())
+		}
+	}
+}
+
+func TestNewerMinorVersion(t *testing.T) {
+	cases := map[string]bool{
+		"1.19.0":     false,
+		"1.19.2+ent": false,
+		"1.12.0":     false,
+		"1.20.0":     true,
+		"1.21.1+ent": true,
+		"2.0.0":      true,
+		"unknown":    false,
+	}
+
+	for version, expected := range cases {
+		if newer := newerMinorVersion(version, "1.19"); newer != expected {
+			t.Errorf("expected %s to be newer than 1.19: %v, got %v", version, expected, newer)
+		}
+	}
+}
+
+func TestTranslateLegacyACLTokens(t *testing.T) {
+	const (
+		clientRules = `key "" { policy = "read" }`
+		otherRules  = `service "" { policy = "write" }`
+	)
+
+	globalManagement := &api.ACLTokenPolicyLink{ID: "00000000-0000-0000-0000-000000000001", Name: "global-management"}
+	existing := &api.ACLTokenPolicyLink{ID: "policy-id", Name: "web"}
+
+	cases := map[string]struct {
+		tokens map[string]api.ACLToken
+		// translated is the translation of the rules every token is expected to be
+		// linked to, and an empty string for a token which is left as it is
+		translated   map[string]string
+		policies     int
+		translations int
+	}{
+		"client token": {
+			tokens: map[string]api.ACLToken{
+				"client": {AccessorID: "client", SecretID: "client-secret", Rules: clientRules},
+			},
+			translated:   map[string]string{"client": "translated " + clientRules},
+			policies:     1,
+			translations: 1,
+		},
+		"client token with policies": {
+			tokens: map[string]api.ACLToken{
+				"client": {AccessorID: "client", SecretID: "client-secret", Rules: clientRules, Policies: []*api.ACLTokenPolicyLink{existing}},
+			},
+			translated:   map[string]string{"client": "translated " + clientRules},
+			policies:     1,
+			translations: 1,
+		},
+		// Consul links legacy management tokens to the global-management policy
+		// when it upgrades them so they carry no rules
+		"management token": {
+			tokens: map[string]api.ACLToken{
+				"management": {AccessorID: "management", SecretID: "management-secret", Policies: []*api.ACLTokenPolicyLink{globalManagement}},
+			},
+			translated: map[string]string{"management": ""},
+		},
+		"shared rules": {
+			tokens: map[string]api.ACLToken{
+				"first":  {AccessorID: "first", SecretID: "first-secret", Rules: clientRules},
+				"second": {AccessorID: "second", SecretID: "second-secret", Rules: clientRules},
+				"other":  {AccessorID: "other", SecretID: "other-secret", Rules: otherRules},
+			},
+			translated: map[string]string{
+				"first":  "translated " + clientRules,
+				"second": "translated " + clientRules,
+				"other":  "translated " + otherRules,
+			},
+			policies:     2,
+			translations: 2,
+		},
+		"empty rules": {
+			tokens: map[string]api.ACLToken{
+				"token": {AccessorID: "token", SecretID: "token-secret", Policies: []*api.ACLTokenPolicyLink{existing}},
+			},
+			translated: map[string]string{"token": ""},
+		},
+	}
+
+	for name, tcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			translations := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/v1/acl/rules/translate" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					http.NotFound(w, r)
+					return
+				}
+				translations++
+				var rules bytes.Buffer
+				rules.ReadFrom(r.Body)
+				w.Write([]byte("translated " + rules.String()))
+			}))
+			defer server.Close()
+
+			client, err := api.NewClient(&api.Config{Address: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			policies := map[string]api.ACLPolicy{existing.ID: {ID: existing.ID, Name: existing.Name}}
+			original := make(map[string]api.ACLToken)
+			for accessorID, token := range tcase.tokens {
+				original[accessorID] = token
+			}
+
+			if err := translateLegacyACLTokens(client, policies, tcase.tokens); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if translations != tcase.translations {
+				t.Fatalf("expected %d rule translations, got %d", tcase.translations, translations)
+			}
+			if len(policies) != tcase.policies+1 {
+				t.Fatalf("expected %d synthesized policies, got %+v", tcase.policies, policies)
+			}
+
+			for accessorID, rules := range tcase.translated {
+				token := tcase.tokens[accessorID]
+				before := original[accessorID]
+				if token.SecretID != before.SecretID || token.Rules != "" {
+					t.Fatalf("expected token %s to keep its secret without rules, got %+v", accessorID, token)
+				}
+
+				if rules == "" {
+					if len(token.Policies) != len(before.Policies) {
+						t.Fatalf("expected token %s to be left as it is, got %+v", accessorID, token.Policies)
+					}
+					continue
+				}
+
+				if len(token.Policies) != len(before.Policies)+1 {
+					t.Fatalf("expected token %s to be linked to one more policy, got %+v", accessorID, token.Policies)
+				}
+				link := token.Policies[len(token.Policies)-1]
+				policy, ok := policies[link.ID]
+				if !ok || policy.Name != link.Name || policy.Rules != rules {
+					t.Fatalf("expected token %s to be linked to a policy with the translated rules, got %+v and %+v", accessorID, link, policy)
+				}
+			}
+		})
+	}
+}
+
+func TestExportCatalog(t *testing.T) {
+	// the nodes of every partition along with whether they belong to an agent
+	partitionNodes := map[string]map[string]bool{
+		"":      {"agent": true, "external": false},
+		"team":  {"team-agent": true, "team-external": false},
+		"agent": {"only-agent": true},
+		"empty": {},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		partition := r.URL.Query().Get("partition")
+		switch {
+		case r.URL.Path == "/v1/catalog/nodes":
+			nodes := []*api.Node{}
+			for node := range partitionNodes[partition] {
+				nodes = append(nodes, &api.Node{Node: node, Partition: partition})
+			}
+			json.NewEncoder(w).Encode(nodes)
+		case strings.HasPrefix(r.URL.Path, "/v1/health/node/"):
+			node := strings.TrimPrefix(r.URL.Path, "/v1/health/node/")
+			checks := api.HealthChecks{}
+			if partitionNodes[partition][node] {
+				checks = append(checks, &api.HealthCheck{Node: node, CheckID: serfHealthCheckID})
+			}
+			json.NewEncoder(w).Encode(checks)
+		case strings.HasPrefix(r.URL.Path, "/v1/catalog/node-services/"):
+			node := strings.TrimPrefix(r.URL.Path, "/v1/catalog/node-services/")
+			json.NewEncoder(w).Encode(api.CatalogNodeServiceList{
+				Node:     &api.Node{Node: node, Partition: partition},
+				Services: []*api.AgentService{{ID: node + "-service", Service: "service"}},
+			})
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	previous := hclog.Default()
+	hclog.SetDefault(hclog.New(&hclog.LoggerOptions{Output: &out}))
+	defer hclog.SetDefault(previous)
+
+	nodes, err := exportCatalog(client, nil, []string{"agent", "empty", "team"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(nodes) != 1 || nodes[0].Node.Node != "external" || len(nodes[0].Services) != 1 {
+		t.Fatalf("expected only the external node of the default partition, got %+v", nodes)
+	}
+
+	logs := out.String()
+	if strings.Count(logs, "[WARN]") != 1 || !strings.Contains(logs, "partition=team") {
+		t.Fatalf("expected a single warning for the team partition, got:\n%s", logs)
+	}
+}
@@ -1,40 +1,189 @@
 package migrate
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 )
 
+// AuthMethodTokenMode controls what happens to tokens which were issued by
+// logging in through an auth method. Consul refuses to create such tokens directly.
+type AuthMethodTokenMode string
+
+const (
+	// AuthMethodTokensSkip does not import the tokens. Clients have to log in
+	// again to get new tokens.
+	AuthMethodTokensSkip AuthMethodTokenMode = "skip"
+	// AuthMethodTokensRecreate creates the tokens as regular tokens with the
+	// same links to roles, policies and identities.
+	AuthMethodTokensRecreate AuthMethodTokenMode = "recreate"
+	// AuthMethodTokensFail fails the import when it contains such a token
+	AuthMethodTokensFail AuthMethodTokenMode = "fail"
+)
+
+// ConflictMode controls what happens to objects which already exist on the target
+// but differ from the imported objects. Objects which match are always left alone.
+type ConflictMode string
+
+const (
+	// ConflictFail fails the import
+	ConflictFail ConflictMode = "fail"
+	// ConflictSkip keeps the object of the target
+	ConflictSkip ConflictMode = "skip"
+	// ConflictOverwrite updates the object of the target to the imported one
+	ConflictOverwrite ConflictMode = "overwrite"
+	// ConflictRename imports policies and roles under a new name made by adding
+	// a suffix to their name. Other objects of the target are kept.
+	ConflictRename ConflictMode = "rename"
+)
+
+// ImportOptions controls how data is rewritten while it is imported
+type ImportOptions struct {
+	// DatacenterMap maps datacenter names in the source data to the
+	// datacenter names to use on the target
+	DatacenterMap map[string]string
+	// AuthMethodTokens is how to handle tokens issued by auth methods.
+	// Defaults to AuthMethodTokensSkip.
+	AuthMethodTokens AuthMethodTokenMode
+	// OnConflict is how to handle objects which already exist on the target.
+	// Defaults to ConflictOverwrite.
+	OnConflict ConflictMode
+	// RenameSuffix is added to the names of policies and roles which get
+	// renamed with ConflictRename
+	RenameSuffix string
+	// ImportConnectCA writes the Connect CA configuration of the data to the
+	// target. It is off by default as setting the configuration of a target
+	// which already issues certificates rotates its CA.
+	ImportConnectCA bool
+	// Journal records every object the import creates or updates so that the
+	// import can be rolled back. Nothing is recorded when it is nil.
+	Journal *Journal
+	// Checkpoint records the progress of the import. When it holds the progress
+	// of an interrupted import, the objects imported before are skipped.
+	Checkpoint *Checkpoint
+}
+
 type importer struct {
-	client    *api.Client
-	logger    hclog.Logger
-	opts      *api.WriteOptions
-	qopts     *api.QueryOptions
+	client     *api.Client
+	enterprise bool
+	logger     hclog.Logger
+	opts       *api.WriteOptions
+	qopts      *api.QueryOptions
+	// partition is the admin partition being imported into. It is empty for
+	// the default partition.
+	partition string
+	dcMap     map[string]string
+	// datacenters are the names of all datacenters federated with the target
+	datacenters      map[string]bool
+	authMethodTokens AuthMethodTokenMode
+	onConflict       ConflictMode
+	renameSuffix     string
+	connectCA        bool
+	// sourceDC is the datacenter the data was exported from mapped to its name
+	// on the target. Local tokens are only imported into this datacenter.
+	sourceDC string
+	// targetDC is the datacenter being imported into and primaryDC the primary
+	// datacenter of its federation. Global tokens are only imported into the primary.
+	targetDC  string
+	primaryDC string
 	policyMap map[string]string
 	roleMap   map[string]string
+	// authMethodMap maps the source auth method name to the name on the target
+	authMethodMap map[string]string
+	// tokenSecretMap maps the source token secret to the secret on the target
+	tokenSecretMap map[string]string
+	// renamedRoles maps the scoped name of a role that got renamed on the target
+	// to its new name
+	renamedRoles map[string]string
+	// createdNamespaces holds the partition qualified names of the namespaces
+	// created by the import
+	createdNamespaces map[string]bool
+	journal           *Journal
+	checkpoint        *Checkpoint
+	// plan collects what the import would do when planning. Nothing is written
+	// to the target while it is set.
+	plan *ImportPlan
+	// missing is set when planning within a partition or namespace which would be
+	// created, so there is nothing within it to look up on the target
+	missing bool
 }
 
-func Import(client *api.Client, data *Data) error {
-	imp := importer{
-		client:    client,
-		logger:    hclog.Default(),
-		policyMap: make(map[string]string),
-		roleMap:   make(map[string]string),
+func Import(client *api.Client, data *Data, opts *ImportOptions) error {
+	imp, err := newImporter(client, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := imp.checkTokenLocality(data); err != nil {
+		return err
 	}
 
-	if ent, err := isEnterprise(client); err != nil {
-		return fmt.Errorf("error determining whether Consul is OSS or Enterprise: %w", err)
-	} else if ent {
-		return imp.importEnterprise(data)
+	var entries []namespacedConfigEntry
+	if imp.enterprise {
+		entries, err = imp.importEnterprise(data)
 	} else {
-		return imp.importOSS(data)
+		entries, err = imp.importOSS(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	return imp.importGlobalData(data, entries)
+}
+
+// newImporter creates an importer for the target along with what it needs to know about the target
+func newImporter(client *api.Client, opts *ImportOptions) (*importer, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	imp := &importer{
+		client:            client,
+		logger:            hclog.Default(),
+		dcMap:             opts.DatacenterMap,
+		authMethodTokens:  opts.AuthMethodTokens,
+		onConflict:        opts.OnConflict,
+		renameSuffix:      opts.RenameSuffix,
+		connectCA:         opts.ImportConnectCA,
+		policyMap:         make(map[string]string),
+		roleMap:           make(map[string]string),
+		authMethodMap:     make(map[string]string),
+		tokenSecretMap:    make(map[string]string),
+		renamedRoles:      make(map[string]string),
+		createdNamespaces: make(map[string]bool),
+		journal:           opts.Journal,
+		checkpoint:        opts.Checkpoint,
+	}
+	opts.Checkpoint.restore(imp)
+
+	ent, err := isEnterprise(client)
+	if err != nil {
+		return nil, fmt.Errorf("error determining whether Consul is OSS or Enterprise: %w", err)
+	}
+	imp.enterprise = ent
+
+	datacenters, err := client.Catalog().Datacenters()
+	if err != nil {
+		return nil, fmt.Errorf("error listing the datacenters known to the target: %w", err)
+	}
+	imp.datacenters = make(map[string]bool)
+	for _, dc := range datacenters {
+		imp.datacenters[dc] = true
 	}
+
+	return imp, nil
 }
 
 func (imp *importer) WithLoggerAndOpts(logger hclog.Logger, opts *api.WriteOptions, qopts *api.QueryOptions) *importer {
-	newImp := imp
+	newImp := *imp
 	if logger != nil {
 		newImp.logger = logger
 	}
@@ -46,57 +195,471 @@ func (imp *importer) WithLoggerAndOpts(logger hclog.Logger, opts *api.WriteOptio
 		newImp.qopts = qopts
 	}
 
-	return newImp
+	return &newImp
 }
 
-func (imp *importer) importEnterprise(data *Data) error {
+// importEnterprise imports the namespaced data and returns the config entries
+// to be written once all the namespaces exist.
+func (imp *importer) importEnterprise(data *Data) ([]namespacedConfigEntry, error) {
 	imp.logger.Debug("importing data to Consul Enterprise")
 
 	if !data.Enterprise {
 		// the data was from oss so we just allow the data to go into
 		// the default namespace
-		aclData := data.ACLData
-		return imp.importACLData(&aclData)
+		scoped := data.ScopedData
+		if err := imp.importScopedData(&scoped); err != nil {
+			return nil, err
+		}
+		return namespacedConfigEntries("", "", scoped.ConfigEntries), nil
+	}
+
+	entries, err := imp.importNamespaces(data.Namespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data.Partitions) == 0 {
+		return entries, nil
+	}
+
+	partitioned, err := supportsPartitions(imp.client)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, partition := range data.Partitions {
+		if !partitioned {
+			imp.logger.Warn("skipping partition as the target does not support admin partitions", "partition", partition.Definition.Name)
+			continue
+		}
+
+		partitionEntries, err := imp.importPartition(&partition)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, partitionEntries...)
+	}
+
+	return entries, nil
+}
+
+// importPartition creates the partition before importing the namespaces within it
+func (imp *importer) importPartition(partitionData *PartitionData) ([]namespacedConfigEntry, error) {
+	definition := partitionData.Definition
+	definition.CreateIndex = 0
+	definition.ModifyIndex = 0
+
+	logger := imp.logger.With("partition", definition.Name)
+
+	created := false
+	err := imp.once(checkpointKey(JournalPartition, "", "", definition.Name), func() error {
+		var err error
+		created, err = imp.upsertPartition(&definition, logger)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	newImp := imp.WithLoggerAndOpts(logger, nil, nil)
+	newImp.partition = definition.Name
+	newImp.missing = imp.plan != nil && created
+	return newImp.importNamespaces(partitionData.Namespaces)
+}
+
+// upsertPartition creates the partition or reconciles it with the partition of the same
+// name on the target according to the conflict mode. It returns whether the partition
+// was created.
+func (imp *importer) upsertPartition(definition *api.Partition, logger hclog.Logger) (bool, error) {
+	partitions := imp.client.Partitions()
+
+	existing, _, err := partitions.Read(context.Background(), definition.Name, nil)
+	if err != nil {
+		return false, fmt.Errorf("error looking up partition %s: %w", definition.Name, err)
+	}
+
+	switch {
+	case existing == nil:
+		if imp.plan != nil {
+			imp.planChange(PlanCreate, "Partition", definition.Name, "")
+			return true, nil
+		}
+		if err := imp.journalCreate(scopedEntry(JournalPartition, definition.Name, nil)); err != nil {
+			return false, err
+		}
+		if _, _, err := partitions.Create(context.Background(), definition, nil); err != nil {
+			return false, fmt.Errorf("error creating partition %s: %w", definition.Name, err)
+		}
+		logger.Info("created Partition")
+		return true, nil
+	case existing.Description == definition.Description:
+		imp.planChange(PlanNoOp, "Partition", definition.Name, "")
+		logger.Info("Partition is up to date")
+	default:
+		overwrite, err := imp.resolveConflict("Partition", definition.Name)
+		if err != nil || !overwrite {
+			return false, err
+		}
+
+		if imp.plan != nil {
+			imp.planChange(PlanUpdate, "Partition", definition.Name, "")
+			return false, nil
+		}
+		if err := imp.journalUpdate(scopedEntry(JournalPartition, definition.Name, nil), existing); err != nil {
+			return false, err
+		}
+		if _, _, err := partitions.Update(context.Background(), definition, nil); err != nil {
+			return false, fmt.Errorf("error updating partition %s: %w", definition.Name, err)
+		}
+		logger.Info("updated Partition")
 	}
 
-	for _, ns := range data.Namespaces {
+	return false, nil
+}
+
+// importNamespaces imports the namespaces within the importer's partition and returns
+// their config entries to be written once all namespaces exist.
+//
+// Namespaces are imported in two phases. The ACL defaults of a namespace link to
+// policies and roles which may live in other namespaces, so the namespaces are first
+// created without them and only get their defaults once all ACL data is imported.
+func (imp *importer) importNamespaces(namespaces map[string]NamespaceData) ([]namespacedConfigEntry, error) {
+	var entries []namespacedConfigEntry
+	for _, ns := range namespaces {
 		if err := imp.importNamespace(&ns); err != nil {
-			return err
+			return nil, err
 		}
+		entries = append(entries, namespacedConfigEntries(imp.partition, ns.Definition.Name, ns.ConfigEntries)...)
 	}
 
-	return nil
+	for _, ns := range namespaces {
+		key := checkpointKey(checkpointNamespaceACLDefaults, imp.partition, "", ns.Definition.Name)
+		err := imp.once(key, func() error {
+			return imp.importNamespaceACLDefaults(ns.Definition)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
 }
 
-func (imp *importer) importOSS(data *Data) error {
+// importOSS imports the data from the default namespace and returns its config
+// entries to be written after the rest of the data.
+func (imp *importer) importOSS(data *Data) ([]namespacedConfigEntry, error) {
 	imp.logger.Debug("importing data to Consul OSS")
 
-	aclData := data.ACLData
+	scoped := data.ScopedData
 	if data.Enterprise {
 		// if we are importing data from enterprise to oss
 		// then the only stuff we can import is in the default ns
-		aclData = data.Namespaces["default"].ACLData
+		scoped = data.Namespaces["default"].ScopedData
+	}
+
+	if err := imp.importScopedData(&scoped); err != nil {
+		return nil, err
+	}
+
+	return namespacedConfigEntries("", "", scoped.ConfigEntries), nil
+}
+
+// importGlobalData imports the data that is not scoped to a namespace along with
+// the config entries, which may reference services in any namespace.
+func (imp *importer) importGlobalData(data *Data, entries []namespacedConfigEntry) error {
+	intentionEntries, err := imp.importIntentions(data.Intentions)
+	if err != nil {
+		return err
+	}
+
+	if err := imp.importConfigEntries(append(entries, intentionEntries...)); err != nil {
+		return err
+	}
+
+	if err := imp.importPreparedQueries(data.PreparedQueries); err != nil {
+		return fmt.Errorf("failed to import prepared queries: %w", err)
+	}
+
+	if err := imp.importCatalog(data.Catalog); err != nil {
+		return fmt.Errorf("failed to import catalog: %w", err)
+	}
+
+	err = imp.once(checkpointKey(JournalConnectCA, "", "", ""), func() error {
+		return imp.importConnectCA(data.ConnectCA)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import Connect CA configuration: %w", err)
+	}
+
+	if err := imp.importOperatorData(data.Operator); err != nil {
+		return fmt.Errorf("failed to import operator settings: %w", err)
+	}
+
+	err = imp.once(checkpointKey(JournalGossipKey, "", "", ""), func() error {
+		return imp.importKeyring(data.Keyring)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import gossip encryption keyring: %w", err)
+	}
+
+	return nil
+}
+
+// checkTokenLocality determines which tokens can be imported into the target datacenter.
+// Global tokens are replicated from the primary datacenter so they are only imported
+// there. Local tokens only get imported into the datacenter they were exported from.
+func (imp *importer) checkTokenLocality(data *Data) error {
+	targetDC, primaryDC, err := agentDatacenters(imp.client)
+	if err != nil {
+		return err
+	}
+
+	imp.targetDC = targetDC
+	imp.primaryDC = primaryDC
+	if data.Datacenter != "" {
+		imp.sourceDC = imp.mapDatacenter(data.Datacenter)
+	} else {
+		imp.logger.Warn("the data does not record the datacenter it was exported from, local tokens will be imported as is")
+	}
+
+	if imp.sourceDC != "" && imp.sourceDC != targetDC {
+		imp.logger.Warn("local tokens will not be imported as the target is not the datacenter they were exported from",
+			"source-datacenter", imp.sourceDC, "target-datacenter", targetDC)
+	}
+
+	if targetDC != primaryDC {
+		imp.logger.Warn("global tokens will not be imported as the target is a secondary datacenter which replicates them from the primary",
+			"target-datacenter", targetDC, "primary-datacenter", primaryDC)
+	}
+
+	return nil
+}
+
+// mapDatacenter returns the name of the target datacenter for a source datacenter
+func (imp *importer) mapDatacenter(dc string) string {
+	if mapped, ok := imp.dcMap[dc]; ok {
+		return mapped
 	}
+	return dc
+}
+
+// mapScopedDatacenters maps a list of datacenters that an ACL object is scoped to and
+// warns about any datacenter which is not known to the target.
+func (imp *importer) mapScopedDatacenters(dcs []string, logger hclog.Logger) []string {
+	if len(dcs) == 0 {
+		return dcs
+	}
+
+	mapped := make([]string, 0, len(dcs))
+	for _, dc := range dcs {
+		dc = imp.mapDatacenter(dc)
+		if !imp.datacenters[dc] {
+			logger.Warn("scoped to a datacenter which the target does not know", "datacenter", dc)
+		}
+		mapped = append(mapped, dc)
+	}
+	return mapped
+}
+
+// mapServiceIdentities returns copies of the service identities with their datacenters mapped
+func (imp *importer) mapServiceIdentities(identities []*api.ACLServiceIdentity) []*api.ACLServiceIdentity {
+	var mapped []*api.ACLServiceIdentity
+	for _, identity := range identities {
+		logger := imp.logger.With("service-identity", identity.ServiceName)
+		mapped = append(mapped, &api.ACLServiceIdentity{
+			ServiceName: identity.ServiceName,
+			Datacenters: imp.mapScopedDatacenters(identity.Datacenters, logger),
+		})
+	}
+	return mapped
+}
 
-	return imp.importACLData(&aclData)
+// mapNodeIdentities returns copies of the node identities with their datacenter mapped
+func (imp *importer) mapNodeIdentities(identities []*api.ACLNodeIdentity) []*api.ACLNodeIdentity {
+	var mapped []*api.ACLNodeIdentity
+	for _, identity := range identities {
+		logger := imp.logger.With("node-identity", identity.NodeName)
+		mapped = append(mapped, &api.ACLNodeIdentity{
+			NodeName:   identity.NodeName,
+			Datacenter: imp.mapScopedDatacenters([]string{identity.Datacenter}, logger)[0],
+		})
+	}
+	return mapped
 }
 
+// importNamespace creates the namespace, or updates it when it already exists, and
+// imports the data within it.
 func (imp *importer) importNamespace(nsData *NamespaceData) error {
+	definition := nsData.Definition
+	definition.CreateIndex = 0
+	definition.ModifyIndex = 0
+	definition.Partition = imp.partition
+	// the ACL defaults are set by importNamespaceACLDefaults once the
+	// policies and roles they link to exist
+	definition.ACLs = nil
+
+	logger := imp.logger.With("ns", definition.Name)
+
+	key := checkpointKey(JournalNamespace, imp.partition, "", definition.Name)
+	if !imp.alreadyImported(key) {
+		if err := imp.upsertNamespace(&definition, logger); err != nil {
+			return err
+		}
+
+		var mappings []checkpointMapping
+		if created := imp.partition + "/" + definition.Name; imp.createdNamespaces[created] {
+			mappings = append(mappings, checkpointMapping{Map: checkpointCreatedNamespaces, From: created})
+		}
+		if err := imp.checkpointed(key, mappings...); err != nil {
+			return err
+		}
+	}
+
+	newImp := imp.WithLoggerAndOpts(logger,
+		&api.WriteOptions{Partition: imp.partition, Namespace: definition.Name},
+		&api.QueryOptions{Partition: imp.partition, Namespace: definition.Name})
+	newImp.missing = imp.missing || (imp.plan != nil && imp.createdNamespaces[imp.partition+"/"+definition.Name])
+	return newImp.importScopedData(&nsData.ScopedData)
+}
+
+// upsertNamespace creates the namespace or reconciles it with the namespace of the same
+// name on the target according to the conflict mode
+func (imp *importer) upsertNamespace(definition *api.Namespace, logger hclog.Logger) error {
 	ns := imp.client.Namespaces()
 
-	nsData.Definition.CreateIndex = 0
-	nsData.Definition.ModifyIndex = 0
+	var existing *api.Namespace
+	if !imp.missing {
+		var err error
+		existing, _, err = ns.Read(definition.Name, &api.QueryOptions{Partition: imp.partition})
+		if err != nil {
+			return fmt.Errorf("error looking up namespace %s: %w", definition.Name, err)
+		}
+	}
+
+	switch {
+	case existing == nil && imp.plan != nil:
+		imp.planChange(PlanCreate, "Namespace", definition.Name, "")
+		imp.createdNamespaces[imp.partition+"/"+definition.Name] = true
+	case existing == nil:
+		if err := imp.journalCreate(scopedEntry(JournalNamespace, definition.Name, &api.WriteOptions{Partition: imp.partition})); err != nil {
+			return err
+		}
+		if _, _, err := ns.Create(definition, &api.WriteOptions{Partition: imp.partition}); err != nil {
+			return fmt.Errorf("error creating namespace %s: %w", definition.Name, err)
+		}
+		logger.Info("created Namespace")
+		imp.createdNamespaces[imp.partition+"/"+definition.Name] = true
+	case existing.Description == definition.Description && sameMeta(existing.Meta, definition.Meta):
+		imp.planChange(PlanNoOp, "Namespace", definition.Name, "")
+		logger.Info("Namespace is up to date")
+	default:
+		overwrite, err := imp.resolveConflict("Namespace", definition.Name)
+		if err != nil || !overwrite {
+			return err
+		}
+
+		if imp.plan != nil {
+			imp.planChange(PlanUpdate, "Namespace", definition.Name, "")
+			return nil
+		}
+
+		// keep the current ACL defaults until importNamespaceACLDefaults replaces them
+		definition.ACLs = existing.ACLs
+		if err := imp.journalUpdate(scopedEntry(JournalNamespace, definition.Name, &api.WriteOptions{Partition: imp.partition}), existing); err != nil {
+			return err
+		}
+		if _, _, err := ns.Update(definition, &api.WriteOptions{Partition: imp.partition}); err != nil {
+			return fmt.Errorf("error updating namespace %s: %w", definition.Name, err)
+		}
+		logger.Info("updated Namespace")
+	}
+
+	return nil
+}
+
+// importNamespaceACLDefaults sets the policy and role defaults of a namespace, linking them
+// to the policies and roles as they were created on the target. Defaults which already
+// match are left alone.
+func (imp *importer) importNamespaceACLDefaults(definition api.Namespace) error {
+	if imp.plan != nil && imp.createdNamespaces[imp.partition+"/"+definition.Name] {
+		// the defaults of new namespaces are part of creating them
+		return nil
+	}
+
+	acls := &api.NamespaceACLConfig{}
+	if definition.ACLs != nil {
+		for _, link := range definition.ACLs.PolicyDefaults {
+			acls.PolicyDefaults = append(acls.PolicyDefaults, resolveACLLink(link, imp.policyMap))
+		}
+		for _, link := range definition.ACLs.RoleDefaults {
+			acls.RoleDefaults = append(acls.RoleDefaults, resolveACLLink(link, imp.roleMap))
+		}
+	}
 
-	newNS, _, err := ns.Create(&nsData.Definition, imp.opts)
+	existing, _, err := imp.client.Namespaces().Read(definition.Name, &api.QueryOptions{Partition: imp.partition})
 	if err != nil {
-		return fmt.Errorf("error creating namespace %s: %w", nsData.Definition.Name, err)
+		return fmt.Errorf("error looking up namespace %s: %w", definition.Name, err)
 	}
 
-	logger := imp.logger.With("ns", nsData.Definition.Name)
-	logger.Info("created Namespace")
+	current := &api.NamespaceACLConfig{}
+	if existing != nil && existing.ACLs != nil {
+		current = existing.ACLs
+	}
+
+	if aclLinksMatch(linkPointers(current.PolicyDefaults), linkPointers(acls.PolicyDefaults)) &&
+		aclLinksMatch(linkPointers(current.RoleDefaults), linkPointers(acls.RoleDefaults)) {
+		return nil
+	}
+
+	if !imp.createdNamespaces[imp.partition+"/"+definition.Name] {
+		overwrite, err := imp.resolveConflict("Namespace ACL defaults", definition.Name)
+		if err != nil || !overwrite {
+			return err
+		}
+	}
+
+	if imp.plan != nil {
+		imp.planChange(PlanUpdate, "Namespace ACL defaults", definition.Name, "")
+		return nil
+	}
+
+	definition.CreateIndex = 0
+	definition.ModifyIndex = 0
+	definition.Partition = imp.partition
+	definition.ACLs = acls
+
+	if err := imp.journalUpdate(scopedEntry(JournalNamespace, definition.Name, &api.WriteOptions{Partition: imp.partition}), existing); err != nil {
+		return err
+	}
+	if _, _, err := imp.client.Namespaces().Update(&definition, &api.WriteOptions{Partition: imp.partition}); err != nil {
+		return fmt.Errorf("error setting the acl defaults of namespace %s: %w", definition.Name, err)
+	}
+
+	imp.logger.Info("updated Namespace ACL defaults", "ns", definition.Name,
+		"policies", len(acls.PolicyDefaults), "roles", len(acls.RoleDefaults))
+	return nil
+}
 
-	newImp := imp.WithLoggerAndOpts(logger, &api.WriteOptions{Namespace: newNS.Name}, &api.QueryOptions{Namespace: newNS.Name})
-	return newImp.importACLData(&nsData.ACLData)
+// resolveACLLink maps a link to a policy or role from its source id to the id on the
+// target. Links to objects which were not imported, such as the builtin
+// global-management policy, are resolved by name on the target instead.
+func resolveACLLink(link api.ACLLink, idMap map[string]string) api.ACLLink {
+	if id, ok := idMap[link.ID]; ok {
+		return api.ACLLink{ID: id}
+	}
+	return api.ACLLink{Name: link.Name}
+}
+
+func (imp *importer) importScopedData(scoped *ScopedData) error {
+	if err := imp.importACLData(&scoped.ACLData); err != nil {
+		return err
+	}
+
+	if imp.plan != nil {
+		// plans only cover the ACL data
+		return nil
+	}
+	return imp.importKV(scoped.KV)
 }
 
 func (imp *importer) importACLData(aclData *ACLData) error {
@@ -108,6 +671,17 @@ func (imp *importer) importACLData(aclData *ACLData) error {
 		return fmt.Errorf("failed to import acl roles: %w", err)
 	}
 
+	// plans leave out auth methods and binding rules
+	if imp.plan == nil {
+		if err := imp.importACLAuthMethods(aclData.ACLAuthMethods); err != nil {
+			return fmt.Errorf("failed to import acl auth methods: %w", err)
+		}
+
+		if err := imp.importACLBindingRules(aclData.ACLBindingRules); err != nil {
+			return fmt.Errorf("failed to import acl binding rules: %w", err)
+		}
+	}
+
 	if err := imp.importACLTokens(aclData.ACLTokens); err != nil {
 		return fmt.Errorf("failed to import acl tokens: %w", err)
 	}
@@ -116,86 +690,1996 @@ func (imp *importer) importACLData(aclData *ACLData) error {
 }
 
 func (imp *importer) importACLPolicies(policies map[string]api.ACLPolicy) error {
-	acls := imp.client.ACL()
-
 	for policyID, policy := range policies {
-		policy.CreateIndex = 0
-		policy.ModifyIndex = 0
-		policy.Hash = nil
-		policy.ID = ""
+		key := imp.scopedKey(JournalACLPolicy, policyID)
+		if imp.alreadyImported(key) {
+			continue
+		}
 
-		newPolicy, _, err := acls.PolicyCreate(&policy, imp.opts)
+		imp.preparePolicy(&policy)
+
+		id, err := imp.upsertPolicy(&policy, policyID)
 		if err != nil {
-			return fmt.Errorf("failed to create policy: %w", err)
+			return err
 		}
+		imp.policyMap[policyID] = id
 
-		imp.logger.Info("created ACL Policy", "id", newPolicy.ID, "from", policyID)
-
-		imp.policyMap[policyID] = newPolicy.ID
+		if err := imp.checkpointed(key, checkpointMapping{Map: checkpointPolicyMap, From: policyID, To: id}); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (imp *importer) importACLRoles(roles map[string]api.ACLRole) error {
+// preparePolicy clears what the target assigns itself and maps the datacenters of a policy
+func (imp *importer) preparePolicy(policy *api.ACLPolicy) {
+	policy.CreateIndex = 0
+	policy.ModifyIndex = 0
+	policy.Hash = nil
+	policy.ID = ""
+	policy.Datacenters = imp.mapScopedDatacenters(policy.Datacenters, imp.logger.With("policy", policy.Name))
+}
+
+// upsertPolicy creates the policy or reconciles it with the policy of the same name on the
+// target according to the conflict mode. It returns the id of the policy that was kept.
+// When renaming, the policy is imported under the first name which is either free or
+// holds a matching policy, so a differing policy of the target is never overwritten.
+func (imp *importer) upsertPolicy(policy *api.ACLPolicy, from string) (string, error) {
 	acls := imp.client.ACL()
+	name := policy.Name
 
-	for roleID, role := range roles {
-		role.CreateIndex = 0
-		role.ModifyIndex = 0
-		role.Hash = nil
-		role.ID = ""
+	for attempt := 1; ; attempt++ {
+		var existing *api.ACLPolicy
+		if !imp.missing {
+			var err error
+			existing, _, err = acls.PolicyReadByName(policy.Name, imp.qopts)
+			if err != nil {
+				return "", fmt.Errorf("failed to look up policy %s: %w", policy.Name, err)
+			}
+		}
 
-		// map the old policy ids to the new ids
-		for _, link := range role.Policies {
-			link.ID = imp.policyMap[link.ID]
+		switch {
+		case existing == nil && imp.plan != nil:
+			imp.planRenamable(PlanCreate, "ACL Policy", name, policy.Name)
+			return plannedID(from), nil
+		case existing == nil:
+			if err := imp.journalCreating(JournalACLPolicy, imp.opts, policy); err != nil {
+				return "", err
+			}
+			newPolicy, _, err := acls.PolicyCreate(policy, imp.opts)
+			if err != nil {
+				return "", fmt.Errorf("failed to create policy: %w", err)
+			}
+			if err := imp.journalCreate(scopedEntry(JournalACLPolicy, newPolicy.ID, imp.opts)); err != nil {
+				return "", err
+			}
+			imp.logger.Info("created ACL Policy", "id", newPolicy.ID, "from", from, "name", policy.Name)
+			return newPolicy.ID, nil
+		case policyMatches(existing, policy):
+			imp.planRenamable(PlanNoOp, "ACL Policy", name, policy.Name)
+			imp.logger.Info("ACL Policy is up to date", "id", existing.ID, "from", from, "name", policy.Name)
+			return existing.ID, nil
+		case imp.onConflict == ConflictRename:
+			taken := policy.Name
+			policy.Name = renamedName(name, imp.renameSuffix, attempt)
+			imp.logger.Warn("renaming ACL Policy as a different policy of the same name exists on the target",
+				"from", from, "name", taken, "renamed", policy.Name)
+			continue
 		}
 
-		newRole, _, err := acls.RoleCreate(&role, imp.opts)
+		overwrite, err := imp.resolveConflict("ACL Policy", policy.Name)
 		if err != nil {
-			return fmt.Errorf("failed to create role: %w", err)
+			return "", err
+		}
+		if !overwrite {
+			return existing.ID, nil
 		}
 
-		imp.logger.Info("created ACL Role", "id", newRole.ID, "from", roleID)
+		if imp.plan != nil {
+			imp.planChange(PlanUpdate, "ACL Policy", policy.Name, "")
+			return existing.ID, nil
+		}
 
-		imp.roleMap[roleID] = newRole.ID
+		if err := imp.journalUpdate(scopedEntry(JournalACLPolicy, existing.ID, imp.opts), existing); err != nil {
+			return "", err
+		}
+		policy.ID = existing.ID
+		if _, _, err := acls.PolicyUpdate(policy, imp.opts); err != nil {
+			return "", fmt.Errorf("failed to update policy: %w", err)
+		}
+		imp.logger.Info("updated ACL Policy", "id", existing.ID, "from", from, "name", policy.Name)
+		return existing.ID, nil
 	}
-
-	return nil
 }
 
-func (imp *importer) importACLTokens(tokens map[string]api.ACLToken) error {
-	acls := imp.client.ACL()
+func (imp *importer) importACLRoles(roles map[string]api.ACLRole) error {
+	for roleID, role := range roles {
+		key := imp.scopedKey(JournalACLRole, roleID)
+		if imp.alreadyImported(key) {
+			continue
+		}
 
-	for _, token := range tokens {
-		token.CreateIndex = 0
-		token.ModifyIndex = 0
-		token.Hash = nil
+		imp.prepareRole(&role)
 
-		// map the old policy ids to the new ids
-		for _, link := range token.Policies {
-			link.ID = imp.policyMap[link.ID]
+		name := role.Name
+		id, err := imp.upsertRole(&role, roleID)
+		if err != nil {
+			return err
+		}
+		imp.roleMap[roleID] = id
+		mappings := []checkpointMapping{{Map: checkpointRoleMap, From: roleID, To: id}}
+		if role.Name != name {
+			imp.renamedRoles[imp.scopedName(name)] = role.Name
+			mappings = append(mappings, checkpointMapping{Map: checkpointRenamedRoles, From: imp.scopedName(name), To: role.Name})
 		}
 
-		// map the old role ids to the new ids
-		for _, link := range token.Roles {
-			link.ID = imp.roleMap[link.ID]
+		if err := imp.checkpointed(key, mappings...); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+// prepareRole clears what the target assigns itself and links a role to the policies
+// and datacenters on the target
+func (imp *importer) prepareRole(role *api.ACLRole) {
+	role.CreateIndex = 0
+	role.ModifyIndex = 0
+	role.Hash = nil
+	role.ID = ""
+
+	// map the old policy ids to the new ids
+	for _, link := range role.Policies {
+		link.ID = imp.policyMap[link.ID]
+	}
+
+	role.ServiceIdentities = imp.mapServiceIdentities(role.ServiceIdentities)
+	role.NodeIdentities = imp.mapNodeIdentities(role.NodeIdentities)
+}
+
+// upsertRole creates the role or reconciles it with the role of the same name on the
+// target according to the conflict mode. It returns the id of the role that was kept.
+// When renaming, the role is imported under the first name which is either free or
+// holds a matching role, so a differing role of the target is never overwritten.
+func (imp *importer) upsertRole(role *api.ACLRole, from string) (string, error) {
+	acls := imp.client.ACL()
+	name := role.Name
 
-		if token.AccessorID == "00000000-0000-0000-0000-000000000002" {
-			_, _, err := acls.TokenUpdate(&token, imp.opts)
+	for attempt := 1; ; attempt++ {
+		var existing *api.ACLRole
+		if !imp.missing {
+			var err error
+			existing, _, err = acls.RoleReadByName(role.Name, imp.qopts)
 			if err != nil {
-				return fmt.Errorf("failed to update anonymous token: %w", err)
+				return "", fmt.Errorf("failed to look up role %s: %w", role.Name, err)
 			}
-			imp.logger.Info("updated anonymous ACL Token", "accessor-id", token.AccessorID)
-		} else {
-			_, _, err := acls.TokenCreate(&token, imp.opts)
+		}
+
+		switch {
+		case existing == nil && imp.plan != nil:
+			imp.planRenamable(PlanCreate, "ACL Role", name, role.Name)
+			return plannedID(from), nil
+		case existing == nil:
+			if err := imp.journalCreating(JournalACLRole, imp.opts, role); err != nil {
+				return "", err
+			}
+			newRole, _, err := acls.RoleCreate(role, imp.opts)
 			if err != nil {
-				return fmt.Errorf("failed to create token: %w", err)
+				return "", fmt.Errorf("failed to create role: %w", err)
 			}
-			imp.logger.Info("created ACL Token", "accessor-id", token.AccessorID)
+			if err := imp.journalCreate(scopedEntry(JournalACLRole, newRole.ID, imp.opts)); err != nil {
+				return "", err
+			}
+			imp.logger.Info("created ACL Role", "id", newRole.ID, "from", from, "name", role.Name)
+			return newRole.ID, nil
+		case roleMatches(existing, role):
+			imp.planRenamable(PlanNoOp, "ACL Role", name, role.Name)
+			imp.logger.Info("ACL Role is up to date", "id", existing.ID, "from", from, "name", role.Name)
+			return existing.ID, nil
+		case imp.onConflict == ConflictRename:
+			taken := role.Name
+			role.Name = renamedName(name, imp.renameSuffix, attempt)
+			imp.logger.Warn("renaming ACL Role as a different role of the same name exists on the target",
+				"from", from, "name", taken, "renamed", role.Name)
+			continue
 		}
 
+		overwrite, err := imp.resolveConflict("ACL Role", role.Name)
+		if err != nil {
+			return "", err
+		}
+		if !overwrite {
+			return existing.ID, nil
+		}
+
+		if imp.plan != nil {
+			imp.planChange(PlanUpdate, "ACL Role", role.Name, "")
+			return existing.ID, nil
+		}
+
+		if err := imp.journalUpdate(scopedEntry(JournalACLRole, existing.ID, imp.opts), existing); err != nil {
+			return "", err
+		}
+		role.ID = existing.ID
+		if _, _, err := acls.RoleUpdate(role, imp.opts); err != nil {
+			return "", fmt.Errorf("failed to update role: %w", err)
+		}
+		imp.logger.Info("updated ACL Role", "id", existing.ID, "from", from, "name", role.Name)
+		return existing.ID, nil
 	}
-	return nil
+}
+
+// renamedName returns the name a policy or role is imported under on the given attempt
+// to find a name which is either free on the target or holds a matching object. The
+// first attempt adds the rename suffix and later ones number it.
+func renamedName(name, suffix string, attempt int) string {
+	if attempt == 1 {
+		return name + suffix
+	}
+	return fmt.Sprintf("%s%s-%d", name, suffix, attempt)
+}
+
+// scopedName qualifies the name of an object with the partition and namespace
+// being imported into
+func (imp *importer) scopedName(name string) string {
+	if imp.opts == nil {
+		return name
+	}
+	return imp.opts.Partition + "/" + imp.opts.Namespace + "/" + name
+}
+
+// resolveConflict applies the conflict mode to an object which already exists on the target
+// but differs from the imported one. It returns whether the object should be overwritten.
+//
+// When planning the conflicts which are not overwritten are recorded instead, including
+// those which would abort the import.
+func (imp *importer) resolveConflict(kind, name string) (bool, error) {
+	switch imp.onConflict {
+	case ConflictFail:
+		if imp.plan != nil {
+			imp.planChange(PlanConflict, kind, name, "the import fails")
+			return false, nil
+		}
+		return false, fmt.Errorf("%s %s already exists on the target and differs from the imported one", kind, name)
+	case ConflictSkip, ConflictRename:
+		// only policies and roles can be renamed, everything else is kept
+		imp.planChange(PlanConflict, kind, name, "the object of the target is kept")
+		imp.logger.Warn("keeping the conflicting object of the target", "kind", kind, "name", name)
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+func (imp *importer) importACLAuthMethods(methods map[string]api.ACLAuthMethod) error {
+	acls := imp.client.ACL()
+
+	for methodName, method := range methods {
+		key := imp.scopedKey(JournalACLAuthMethod, methodName)
+		if imp.alreadyImported(key) {
+			continue
+		}
+
+		method.CreateIndex = 0
+		method.ModifyIndex = 0
+		method.Namespace = ""
+		method.Partition = ""
+
+		existing, _, err := acls.AuthMethodRead(method.Name, imp.qopts)
+		if err != nil {
+			return fmt.Errorf("failed to look up auth method %s: %w", method.Name, err)
+		}
+
+		switch {
+		case existing == nil:
+			if err := imp.journalCreate(scopedEntry(JournalACLAuthMethod, method.Name, imp.opts)); err != nil {
+				return err
+			}
+			if _, _, err := acls.AuthMethodCreate(&method, imp.opts); err != nil {
+				return fmt.Errorf("failed to create auth method: %w", err)
+			}
+			imp.logger.Info("created ACL Auth Method", "name", method.Name, "from", methodName)
+		case authMethodMatches(existing, &method):
+			imp.logger.Info("ACL Auth Method is up to date", "name", method.Name, "from", methodName)
+		default:
+			overwrite, err := imp.resolveConflict("ACL Auth Method", method.Name)
+			if err != nil {
+				return err
+			}
+			if !overwrite {
+				break
+			}
+
+			if err := imp.journalUpdate(scopedEntry(JournalACLAuthMethod, method.Name, imp.opts), existing); err != nil {
+				return err
+			}
+			if _, _, err := acls.AuthMethodUpdate(&method, imp.opts); err != nil {
+				return fmt.Errorf("failed to update auth method: %w", err)
+			}
+			imp.logger.Info("updated ACL Auth Method", "name", method.Name, "from", methodName)
+		}
+
+		imp.authMethodMap[methodName] = method.Name
+
+		if err := imp.checkpointed(key, checkpointMapping{Map: checkpointAuthMethodMap, From: methodName, To: method.Name}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (imp *importer) importACLBindingRules(rules map[string]api.ACLBindingRule) error {
+	acls := imp.client.ACL()
+
+	for ruleID, rule := range rules {
+		key := imp.scopedKey(JournalACLBindingRule, ruleID)
+		if imp.alreadyImported(key) {
+			continue
+		}
+
+		rule.CreateIndex = 0
+		rule.ModifyIndex = 0
+		rule.Namespace = ""
+		rule.Partition = ""
+		rule.ID = ""
+
+		// link the rule to the auth method as it was named on the target
+		methodName, ok := imp.authMethodMap[rule.AuthMethod]
+		if !ok {
+			return fmt.Errorf("binding rule %s references auth method %q which was not imported", ruleID, rule.AuthMethod)
+		}
+		rule.AuthMethod = methodName
+
+		// bind to the role as it was named on the target
+		if rule.BindType == api.BindingRuleBindTypeRole {
+			if renamed, ok := imp.renamedRoles[imp.scopedName(rule.BindName)]; ok {
+				rule.BindName = renamed
+			}
+		}
+
+		if err := imp.checkBindingRuleTarget(&rule); err != nil {
+			return err
+		}
+
+		// binding rules have generated ids so an existing rule is one that
+		// binds the same name for the same selector
+		existingRules, _, err := acls.BindingRuleList(rule.AuthMethod, imp.qopts)
+		if err != nil {
+			return fmt.Errorf("failed to list binding rules of auth method %s: %w", rule.AuthMethod, err)
+		}
+
+		existing := findBindingRule(existingRules, &rule)
+		switch {
+		case existing == nil:
+			if err := imp.journalCreating(JournalACLBindingRule, imp.opts, &rule); err != nil {
+				return err
+			}
+			newRule, _, err := acls.BindingRuleCreate(&rule, imp.opts)
+			if err != nil {
+				return fmt.Errorf("failed to create binding rule: %w", err)
+			}
+			if err := imp.journalCreate(scopedEntry(JournalACLBindingRule, newRule.ID, imp.opts)); err != nil {
+				return err
+			}
+			imp.logger.Info("created ACL Binding Rule", "id", newRule.ID, "from", ruleID, "auth-method", rule.AuthMethod)
+		case existing.Description == rule.Description:
+			imp.logger.Info("ACL Binding Rule is up to date", "id", existing.ID, "from", ruleID, "auth-method", rule.AuthMethod)
+		default:
+			overwrite, err := imp.resolveConflict("ACL Binding Rule", existing.ID)
+			if err != nil {
+				return err
+			}
+			if !overwrite {
+				break
+			}
+
+			if err := imp.journalUpdate(scopedEntry(JournalACLBindingRule, existing.ID, imp.opts), existing); err != nil {
+				return err
+			}
+			rule.ID = existing.ID
+			if _, _, err := acls.BindingRuleUpdate(&rule, imp.opts); err != nil {
+				return fmt.Errorf("failed to update binding rule: %w", err)
+			}
+			imp.logger.Info("updated ACL Binding Rule", "id", existing.ID, "from", ruleID, "auth-method", rule.AuthMethod)
+		}
+
+		if err := imp.checkpointed(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findBindingRule finds the binding rule on the target which corresponds to an imported
+// rule. Binding rules have generated ids so it is the one that binds the same name for
+// the same selector.
+func findBindingRule(existing []*api.ACLBindingRule, rule *api.ACLBindingRule) *api.ACLBindingRule {
+	for _, candidate := range existing {
+		if candidate.Selector == rule.Selector && candidate.BindType == rule.BindType && candidate.BindName == rule.BindName {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// checkBindingRuleTarget warns when a binding rule binds to a role that does not
+// exist on the target. Consul only resolves the bind name at login time so such
+// a rule would be accepted but every login through it would fail.
+func (imp *importer) checkBindingRuleTarget(rule *api.ACLBindingRule) error {
+	if rule.BindType != api.BindingRuleBindTypeRole || strings.Contains(rule.BindName, "${") {
+		// service bindings create service identities which do not need to pre-exist
+		// and templated names can only be resolved during login
+		return nil
+	}
+
+	role, _, err := imp.client.ACL().RoleReadByName(rule.BindName, imp.qopts)
+	if err != nil {
+		return fmt.Errorf("failed to verify role %q bound by binding rule: %w", rule.BindName, err)
+	}
+
+	if role == nil {
+		imp.logger.Warn("binding rule binds to a role that does not exist on the target", "auth-method", rule.AuthMethod, "role", rule.BindName)
+	}
+
+	return nil
+}
+
+func (imp *importer) importACLTokens(tokens map[string]api.ACLToken) error {
+	acls := imp.client.ACL()
+
+	for _, token := range tokens {
+		key := imp.scopedKey(JournalACLToken, token.AccessorID)
+		if imp.alreadyImported(key) {
+			continue
+		}
+
+		ok, err := imp.prepareToken(&token)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		// tokens keep their accessor id so the anonymous token and tokens from
+		// an earlier import already exist
+		var existing *api.ACLToken
+		if !imp.missing {
+			existing, _, err = acls.TokenRead(token.AccessorID, imp.qopts)
+			if err != nil && !isACLNotFoundError(err) {
+				return fmt.Errorf("failed to look up token %s: %w", token.AccessorID, err)
+			}
+		}
+
+		switch {
+		case existing == nil && imp.plan != nil:
+			imp.planChange(PlanCreate, "ACL Token", token.AccessorID, "")
+		case existing == nil:
+			if err := imp.journalCreate(scopedEntry(JournalACLToken, token.AccessorID, imp.opts)); err != nil {
+				return err
+			}
+			newToken, _, err := acls.TokenCreate(&token, imp.opts)
+			if err != nil {
+				return fmt.Errorf("failed to create token: %w", err)
+			}
+			imp.logger.Info("created ACL Token", "accessor-id", token.AccessorID)
+			imp.tokenSecretMap[token.SecretID] = newToken.SecretID
+		case existing.SecretID == token.SecretID && existing.Local == token.Local && tokenMatches(existing, &token):
+			imp.planChange(PlanNoOp, "ACL Token", token.AccessorID, "")
+			imp.logger.Info("ACL Token is up to date", "accessor-id", token.AccessorID)
+			imp.tokenSecretMap[token.SecretID] = existing.SecretID
+		default:
+			overwrite, err := imp.resolveConflict("ACL Token", token.AccessorID)
+			if err != nil {
+				return err
+			}
+
+			imp.tokenSecretMap[token.SecretID] = existing.SecretID
+			if !overwrite {
+				break
+			}
+
+			if existing.SecretID != token.SecretID || existing.Local != token.Local {
+				// neither can be changed once the token exists
+				if imp.plan != nil {
+					imp.planChange(PlanConflict, "ACL Token", token.AccessorID, "the secret id or locality cannot be changed so the import fails")
+					break
+				}
+				return fmt.Errorf("token %s already exists on the target with a different secret id or locality", token.AccessorID)
+			}
+
+			if imp.plan != nil {
+				imp.planChange(PlanUpdate, "ACL Token", token.AccessorID, "")
+				break
+			}
+
+			if err := imp.journalUpdate(scopedEntry(JournalACLToken, existing.AccessorID, imp.opts), existing); err != nil {
+				return err
+			}
+			if _, _, err := acls.TokenUpdate(&token, imp.opts); err != nil {
+				return fmt.Errorf("failed to update token: %w", err)
+			}
+			imp.logger.Info("updated ACL Token", "accessor-id", token.AccessorID)
+		}
+
+		mapping := checkpointMapping{Map: checkpointTokenSecretMap, From: token.SecretID, To: imp.tokenSecretMap[token.SecretID]}
+		if err := imp.checkpointed(key, mapping); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// policyMatches reports whether an existing policy already is the desired policy
+func policyMatches(existing, desired *api.ACLPolicy) bool {
+	return existing.Description == desired.Description &&
+		existing.Rules == desired.Rules &&
+		sameStrings(existing.Datacenters, desired.Datacenters)
+}
+
+// roleMatches reports whether an existing role already is the desired role
+func roleMatches(existing, desired *api.ACLRole) bool {
+	return existing.Description == desired.Description &&
+		aclLinksMatch(existing.Policies, desired.Policies) &&
+		sameStrings(serviceIdentityKeys(existing.ServiceIdentities), serviceIdentityKeys(desired.ServiceIdentities)) &&
+		sameStrings(nodeIdentityKeys(existing.NodeIdentities), nodeIdentityKeys(desired.NodeIdentities))
+}
+
+// tokenMatches reports whether an existing token already is the desired token
+func tokenMatches(existing, desired *api.ACLToken) bool {
+	return existing.Description == desired.Description &&
+		aclLinksMatch(existing.Policies, desired.Policies) &&
+		aclLinksMatch(existing.Roles, desired.Roles) &&
+		sameStrings(serviceIdentityKeys(existing.ServiceIdentities), serviceIdentityKeys(desired.ServiceIdentities)) &&
+		sameStrings(nodeIdentityKeys(existing.NodeIdentities), nodeIdentityKeys(desired.NodeIdentities))
+}
+
+// authMethodMatches reports whether an existing auth method already is the desired auth method
+func authMethodMatches(existing, desired *api.ACLAuthMethod) bool {
+	return existing.Type == desired.Type &&
+		existing.DisplayName == desired.DisplayName &&
+		existing.Description == desired.Description &&
+		existing.MaxTokenTTL == desired.MaxTokenTTL &&
+		existing.TokenLocality == desired.TokenLocality &&
+		reflect.DeepEqual(existing.Config, desired.Config) &&
+		reflect.DeepEqual(existing.NamespaceRules, desired.NamespaceRules)
+}
+
+// aclLinksMatch reports whether the existing links are the desired links in any order.
+// Desired links without an id are links by name.
+func aclLinksMatch(existing, desired []*api.ACLLink) bool {
+	if len(existing) != len(desired) {
+		return false
+	}
+
+	for _, want := range desired {
+		found := false
+		for _, have := range existing {
+			if (want.ID != "" && want.ID == have.ID) || (want.ID == "" && want.Name == have.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func linkPointers(links []api.ACLLink) []*api.ACLLink {
+	pointers := make([]*api.ACLLink, 0, len(links))
+	for i := range links {
+		pointers = append(pointers, &links[i])
+	}
+	return pointers
+}
+
+func serviceIdentityKeys(identities []*api.ACLServiceIdentity) []string {
+	keys := make([]string, 0, len(identities))
+	for _, identity := range identities {
+		dcs := append([]string{}, identity.Datacenters...)
+		sort.Strings(dcs)
+		keys = append(keys, identity.ServiceName+"@"+strings.Join(dcs, ","))
+	}
+	return keys
+}
+
+func nodeIdentityKeys(identities []*api.ACLNodeIdentity) []string {
+	keys := make([]string, 0, len(identities))
+	for _, identity := range identities {
+		keys = append(keys, identity.NodeName+"@"+identity.Datacenter)
+	}
+	return keys
+}
+
+// sameStrings reports whether two lists hold the same strings in any order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	counts := make(map[string]int, len(a))
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		if counts[s] == 0 {
+			return false
+		}
+		counts[s]--
+	}
+	return true
+}
+
+// sameMeta reports whether two sets of metadata are the same, treating nil and empty alike
+func sameMeta(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// minTokenTTL is the default minimum time a token has to live for Consul to create it
+const minTokenTTL = time.Minute
+
+// prepareToken clears what the target assigns itself and links a token to the policies, roles
+// and datacenters on the target. It returns whether the token is to be imported at all.
+func (imp *importer) prepareToken(token *api.ACLToken) (bool, error) {
+	token.CreateIndex = 0
+	token.ModifyIndex = 0
+	token.Hash = nil
+
+	if token.Local && imp.sourceDC != "" && imp.sourceDC != imp.targetDC {
+		imp.logger.Debug("skipping local ACL Token from another datacenter", "accessor-id", token.AccessorID)
+		return false, nil
+	}
+
+	if !token.Local && imp.targetDC != imp.primaryDC {
+		imp.logger.Warn("skipping global ACL Token as it is replicated from the primary datacenter",
+			"accessor-id", token.AccessorID, "primary-datacenter", imp.primaryDC)
+		return false, nil
+	}
+
+	// Consul refuses to create tokens which expire before the minimum TTL, which is a
+	// minute unless configured otherwise. Clearing the expiration would keep them forever.
+	if token.ExpirationTime != nil && time.Until(*token.ExpirationTime) < minTokenTTL {
+		imp.logger.Warn("skipping ACL Token which has expired or is about to expire",
+			"accessor-id", token.AccessorID, "expiration-time", token.ExpirationTime)
+		return false, nil
+	}
+	// exported tokens only carry the expiration time the TTL was turned into
+	token.ExpirationTTL = 0
+
+	if token.AuthMethod != "" {
+		recreate, err := imp.handleAuthMethodToken(token)
+		if err != nil || !recreate {
+			return false, err
+		}
+	}
+
+	// map the old policy ids to the new ids
+	for _, link := range token.Policies {
+		link.ID = imp.policyMap[link.ID]
+	}
+
+	// map the old role ids to the new ids
+	for _, link := range token.Roles {
+		link.ID = imp.roleMap[link.ID]
+	}
+
+	token.ServiceIdentities = imp.mapServiceIdentities(token.ServiceIdentities)
+	token.NodeIdentities = imp.mapNodeIdentities(token.NodeIdentities)
+	return true, nil
+}
+
+// handleAuthMethodToken applies the configured mode to a token issued by an auth method.
+// It returns whether the token should be created as a regular token.
+func (imp *importer) handleAuthMethodToken(token *api.ACLToken) (bool, error) {
+	logger := imp.logger.With("accessor-id", token.AccessorID, "auth-method", token.AuthMethod)
+
+	switch imp.authMethodTokens {
+	case AuthMethodTokensRecreate:
+		logger.Warn("recreating ACL Token issued by an auth method as a regular token")
+		token.AuthMethod = ""
+		token.AuthMethodNamespace = ""
+		return true, nil
+	case AuthMethodTokensFail:
+		if imp.plan != nil {
+			imp.planChange(PlanConflict, "ACL Token", token.AccessorID,
+				fmt.Sprintf("issued by auth method %s so the import fails", token.AuthMethod))
+			return false, nil
+		}
+		return false, fmt.Errorf("token %s was issued by auth method %s and cannot be created directly", token.AccessorID, token.AuthMethod)
+	default:
+		logger.Warn("skipping ACL Token issued by an auth method, clients must log in again")
+		return false, nil
+	}
+}
+
+func (imp *importer) importKV(pairs api.KVPairs) error {
+	kv := imp.client.KV()
+
+	for _, pair := range pairs {
+		key := imp.scopedKey(JournalKV, pair.Key)
+		if imp.alreadyImported(key) {
+			continue
+		}
+
+		pair.CreateIndex = 0
+		pair.ModifyIndex = 0
+		pair.LockIndex = 0
+		// sessions are tied to nodes in the source datacenter so locks cannot be carried over
+		pair.Session = ""
+		pair.Namespace = ""
+		pair.Partition = ""
+
+		previous, _, err := kv.Get(pair.Key, imp.qopts)
+		if err != nil {
+			return fmt.Errorf("failed to look up kv entry %s: %w", pair.Key, err)
+		}
+
+		if previous != nil {
+			write := false
+			if previous.Flags != pair.Flags || !bytes.Equal(previous.Value, pair.Value) {
+				write, err = imp.resolveConflict("KV Entry", pair.Key)
+				if err != nil {
+					return err
+				}
+			}
+			if !write {
+				if err := imp.checkpointed(key); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if previous == nil {
+			err = imp.journalCreate(scopedEntry(JournalKV, pair.Key, imp.opts))
+		} else {
+			err = imp.journalUpdate(scopedEntry(JournalKV, pair.Key, imp.opts), previous)
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := kv.Put(pair, imp.opts); err != nil {
+			return fmt.Errorf("failed to put kv entry %s: %w", pair.Key, err)
+		}
+
+		if err := imp.checkpointed(key); err != nil {
+			return err
+		}
+
+		imp.logger.Debug("imported KV entry", "key", pair.Key)
+	}
+
+	if len(pairs) > 0 {
+		imp.logger.Info("imported KV entries", "count", len(pairs))
+	}
+
+	return nil
+}
+
+// configEntryOrder returns the position of a config entry kind among the kinds to write.
+// Kinds which are not known at all go last.
+func configEntryOrder(kind string) int {
+	if known, ok := knownConfigEntryKinds[kind]; ok {
+		return known.order
+	}
+	return configEntryKinds[len(configEntryKinds)-1].order + 1
+}
+
+// namespacedConfigEntry is a config entry along with the partition and namespace
+// it is to be written into.
+type namespacedConfigEntry struct {
+	partition string
+	namespace string
+	entry     api.ConfigEntry
+	// merged is set when the entry was merged with the entry of the target and the
+	// conflicts between them were resolved already
+	merged bool
+}
+
+// writeOptions returns the options to write the entry with
+func (e namespacedConfigEntry) writeOptions(defaults *api.WriteOptions) *api.WriteOptions {
+	if e.partition != "" || e.namespace != "" {
+		return &api.WriteOptions{Partition: e.partition, Namespace: e.namespace}
+	}
+	return defaults
+}
+
+func (e namespacedConfigEntry) String() string {
+	name := fmt.Sprintf("%s/%s", e.entry.GetKind(), e.entry.GetName())
+	if e.partition != "" {
+		return fmt.Sprintf("%s (partition: %s, ns: %s)", name, e.partition, e.namespace)
+	}
+	if e.namespace != "" {
+		return fmt.Sprintf("%s (ns: %s)", name, e.namespace)
+	}
+	return name
+}
+
+func namespacedConfigEntries(partition, namespace string, entries ConfigEntries) []namespacedConfigEntry {
+	namespaced := make([]namespacedConfigEntry, 0, len(entries))
+	for _, entry := range entries {
+		namespaced = append(namespaced, namespacedConfigEntry{partition: partition, namespace: namespace, entry: entry})
+	}
+	return namespaced
+}
+
+func (imp *importer) importConfigEntries(entries []namespacedConfigEntry) error {
+	configEntries := imp.client.ConfigEntries()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return configEntryOrder(entries[i].entry.GetKind()) < configEntryOrder(entries[j].entry.GetKind())
+	})
+
+	// the kinds written as raw JSON, which are warned about once
+	rawKinds := make(map[string]bool)
+
+	for idx, nsEntry := range entries {
+		entry := nsEntry.entry
+		key := checkpointKey(JournalConfigEntry, nsEntry.partition, nsEntry.namespace, entry.GetKind()+"/"+entry.GetName())
+		if imp.alreadyImported(key) {
+			continue
+		}
+
+		resetConfigEntry(entry)
+		imp.fixGatewayServiceNamespaces(entry)
+
+		opts := nsEntry.writeOptions(imp.opts)
+		previous, err := imp.readConfigEntry(entry, opts)
+		if err != nil {
+			return fmt.Errorf("failed to look up config entry %s: %w", nsEntry, err)
+		}
+
+		if previous != nil {
+			write := nsEntry.merged
+			if !write && !configEntryMatches(previous, entry) {
+				write, err = imp.resolveConflict("Config Entry", nsEntry.String())
+				if err != nil {
+					return err
+				}
+			}
+			if !write {
+				if err := imp.checkpointed(key); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		journalEntry := scopedEntry(JournalConfigEntry, entry.GetName(), opts)
+		journalEntry.EntryKind = entry.GetKind()
+		if previous == nil {
+			err = imp.journalCreate(journalEntry)
+		} else {
+			err = imp.journalUpdate(journalEntry, previous)
+		}
+		if err != nil {
+			return err
+		}
+
+		raw, isRaw := entry.(RawConfigEntry)
+		if isRaw && !rawKinds[entry.GetKind()] {
+			rawKinds[entry.GetKind()] = true
+			imp.logger.Warn("writing config entries of a kind unknown to consul-migrate as raw JSON", "kind", entry.GetKind())
+		}
+		if isRaw {
+			_, err = imp.client.Raw().Write("/v1/config", raw, nil, opts)
+		} else {
+			_, _, err = configEntries.Set(entry, opts)
+		}
+
+		if err != nil && isRaw && isUnsupportedKindError(err) {
+			imp.logger.Warn("skipping config entry of a kind the target does not support", "kind", entry.GetKind(),
+				"name", entry.GetName(), "partition", nsEntry.partition, "ns", nsEntry.namespace)
+			continue
+		} else if err != nil {
+			dependents := configEntryDependents(nsEntry, entries[idx+1:])
+			if len(dependents) == 0 {
+				return fmt.Errorf("failed to write config entry %s: %w", nsEntry, err)
+			}
+			return fmt.Errorf("failed to write config entry %s which the remaining entries [%s] depend on: %w",
+				nsEntry, strings.Join(dependents, ", "), err)
+		}
+
+		if err := imp.checkpointed(key); err != nil {
+			return err
+		}
+
+		imp.logger.Info("wrote Config Entry", "kind", entry.GetKind(), "name", entry.GetName(), "partition", nsEntry.partition, "ns", nsEntry.namespace)
+	}
+
+	return nil
+}
+
+// discoveryChainKinds are the kinds of config entries which make up the discovery chain of a service
+var discoveryChainKinds = map[string]bool{
+	api.ServiceDefaults: true,
+	api.ServiceResolver: true,
+	api.ServiceSplitter: true,
+	api.ServiceRouter:   true,
+}
+
+// configEntryDependents lists the entries which reference a config entry and so cannot be
+// written without it. These are the later parts of the discovery chain of the same service
+// and the gateways which list the service.
+func configEntryDependents(failed namespacedConfigEntry, remaining []namespacedConfigEntry) []string {
+	kind := failed.entry.GetKind()
+	if !discoveryChainKinds[kind] {
+		return nil
+	}
+
+	name := failed.entry.GetName()
+	sameService := func(partition, namespace, service string) bool {
+		return orDefault(partition) == orDefault(failed.partition) &&
+			orDefault(namespace) == orDefault(failed.namespace) &&
+			service == name
+	}
+
+	var dependents []string
+	for _, candidate := range remaining {
+		dependent := false
+		switch entry := candidate.entry.(type) {
+		case *api.IngressGatewayConfigEntry:
+			for _, listener := range entry.Listeners {
+				for _, service := range listener.Services {
+					partition, namespace := service.Partition, service.Namespace
+					if partition == "" {
+						partition = candidate.partition
+					}
+					if namespace == "" {
+						namespace = candidate.namespace
+					}
+					dependent = dependent || sameService(partition, namespace, service.Name)
+				}
+			}
+		case *api.TerminatingGatewayConfigEntry:
+			for _, service := range entry.Services {
+				namespace := service.Namespace
+				if namespace == "" {
+					namespace = candidate.namespace
+				}
+				dependent = dependent || sameService(candidate.partition, namespace, service.Name)
+			}
+		default:
+			dependent = discoveryChainKinds[entry.GetKind()] &&
+				configEntryOrder(entry.GetKind()) > configEntryOrder(kind) &&
+				sameService(candidate.partition, candidate.namespace, entry.GetName())
+		}
+
+		if dependent {
+			dependents = append(dependents, candidate.String())
+		}
+	}
+	return dependents
+}
+
+// configEntryMatches reports whether an existing config entry already is the desired
+// entry. Both are compared as JSON without their raft indexes, partition and namespace.
+func configEntryMatches(existing, desired api.ConfigEntry) bool {
+	resetConfigEntry(existing)
+	existingJSON, err := json.Marshal(existing)
+	if err != nil {
+		return false
+	}
+	desiredJSON, err := json.Marshal(desired)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(existingJSON, desiredJSON)
+}
+
+// readConfigEntry reads the config entry of the target with the same kind and name
+// as the given entry. It returns nil when there is no such entry.
+func (imp *importer) readConfigEntry(entry api.ConfigEntry, opts *api.WriteOptions) (api.ConfigEntry, error) {
+	var qopts *api.QueryOptions
+	if opts != nil {
+		qopts = &api.QueryOptions{Partition: opts.Partition, Namespace: opts.Namespace}
+	}
+
+	var existing api.ConfigEntry
+	var err error
+	if _, isRaw := entry.(RawConfigEntry); isRaw {
+		var raw RawConfigEntry
+		_, err = imp.client.Raw().Query("/v1/config/"+entry.GetKind()+"/"+entry.GetName(), &raw, qopts)
+		existing = raw
+	} else {
+		existing, _, err = imp.client.ConfigEntries().Get(entry.GetKind(), entry.GetName(), qopts)
+	}
+
+	if err != nil && (isNotFoundError(err) || isUnsupportedKindError(err)) {
+		return nil, nil
+	}
+	return existing, err
+}
+
+// fixGatewayServiceNamespaces adjusts the namespaces of the services linked to an
+// ingress or terminating gateway to what the target supports. Consul OSS only has
+// the default namespace so any services in other namespaces have to be dropped.
+func (imp *importer) fixGatewayServiceNamespaces(entry api.ConfigEntry) {
+	if imp.enterprise {
+		return
+	}
+
+	keep := func(namespace, service string) bool {
+		if namespace == "" || namespace == "default" {
+			return true
+		}
+		imp.logger.Warn("dropping gateway service from a non-default namespace as the target does not support namespaces",
+			"kind", entry.GetKind(), "gateway", entry.GetName(), "service", service, "service-ns", namespace)
+		return false
+	}
+
+	switch gateway := entry.(type) {
+	case *api.IngressGatewayConfigEntry:
+		for i, listener := range gateway.Listeners {
+			var services []api.IngressService
+			for _, svc := range listener.Services {
+				if keep(svc.Namespace, svc.Name) {
+					svc.Namespace = ""
+					services = append(services, svc)
+				}
+			}
+			gateway.Listeners[i].Services = services
+		}
+	case *api.TerminatingGatewayConfigEntry:
+		var services []api.LinkedService
+		for _, svc := range gateway.Services {
+			if keep(svc.Namespace, svc.Name) {
+				svc.Namespace = ""
+				services = append(services, svc)
+			}
+		}
+		gateway.Services = services
+	}
+}
+
+// importIntentions imports intentions exported through the legacy intentions API. When
+// the target supports service-intentions config entries the intentions are converted
+// into config entries which are returned to be written along with the others.
+// Otherwise they are created through the legacy API.
+func (imp *importer) importIntentions(intentions []api.Intention) ([]namespacedConfigEntry, error) {
+	if len(intentions) == 0 {
+		return nil, nil
+	}
+
+	supported, err := supportsConfigEntryKind(imp.client, api.ServiceIntentions)
+	if err != nil {
+		return nil, err
+	}
+
+	if supported {
+		entries := imp.convertIntentions(intentions)
+		if err := imp.mergeIntentions(entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+
+	connect := imp.client.Connect()
+
+	existingIntentions, _, err := connect.Intentions(imp.allNamespaces())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list intentions: %w", err)
+	}
+
+	for _, intention := range intentions {
+		if !imp.enterprise && !isDefaultNamespace(intention.SourceNS, intention.DestinationNS) {
+			imp.logger.Warn("skipping intention between non-default namespaces as the target does not support namespaces", "intention", intention.String())
+			continue
+		}
+
+		sourceID := intention.ID
+		key := checkpointKey(JournalIntention, "", "", sourceID)
+		if imp.alreadyImported(key) {
+			continue
+		}
+
+		intention.ID = ""
+		intention.CreateIndex = 0
+		intention.ModifyIndex = 0
+		intention.Hash = nil
+		intention.Precedence = 0
+		intention.CreatedAt = time.Time{}
+		intention.UpdatedAt = time.Time{}
+
+		existing := findIntention(existingIntentions, &intention)
+		switch {
+		case existing == nil:
+			if err := imp.journalCreating(JournalIntention, imp.opts, &intention); err != nil {
+				return nil, err
+			}
+			id, _, err := connect.IntentionCreate(&intention, imp.opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create intention %s: %w", intention.String(), err)
+			}
+			if err := imp.journalCreate(scopedEntry(JournalIntention, id, imp.opts)); err != nil {
+				return nil, err
+			}
+			imp.logger.Info("created Intention", "id", id, "from", sourceID, "intention", intention.String())
+		case existing.Action == intention.Action && existing.Description == intention.Description && sameMeta(existing.Meta, intention.Meta):
+			imp.logger.Info("Intention is up to date", "id", existing.ID, "from", sourceID, "intention", intention.String())
+		default:
+			overwrite, err := imp.resolveConflict("Intention", intention.String())
+			if err != nil {
+				return nil, err
+			}
+			if !overwrite {
+				break
+			}
+
+			if err := imp.journalUpdate(scopedEntry(JournalIntention, existing.ID, imp.opts), existing); err != nil {
+				return nil, err
+			}
+			intention.ID = existing.ID
+			if _, err := connect.IntentionUpdate(&intention, imp.opts); err != nil {
+				return nil, fmt.Errorf("failed to update intention %s: %w", intention.String(), err)
+			}
+			imp.logger.Info("updated Intention", "id", existing.ID, "from", sourceID, "intention", intention.String())
+		}
+
+		if err := imp.checkpointed(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// findIntention finds the intention on the target between the same source and destination
+// as an imported intention. Consul allows only one intention per source and destination,
+// whatever its action, so the action must not be part of the match.
+func findIntention(existing []*api.Intention, intention *api.Intention) *api.Intention {
+	for _, candidate := range existing {
+		if orDefault(candidate.SourcePartition) == orDefault(intention.SourcePartition) &&
+			orDefault(candidate.SourceNS) == orDefault(intention.SourceNS) &&
+			candidate.SourceName == intention.SourceName &&
+			orDefault(candidate.DestinationPartition) == orDefault(intention.DestinationPartition) &&
+			orDefault(candidate.DestinationNS) == orDefault(intention.DestinationNS) &&
+			candidate.DestinationName == intention.DestinationName {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// orDefault returns the name of a partition or namespace with empty meaning the default one
+func orDefault(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
+}
+
+// convertIntentions groups legacy intentions by their destination into service-intentions
+// config entries. Precedence is not stored but computed by Consul from the source and
+// destination names, so keeping the names exactly as they were keeps the precedence.
+func (imp *importer) convertIntentions(intentions []api.Intention) []namespacedConfigEntry {
+	entries := make(map[string]*namespacedConfigEntry)
+	var order []string
+
+	for _, intention := range intentions {
+		logger := imp.logger.With("intention", intention.String())
+
+		if !imp.enterprise && !isDefaultNamespace(intention.SourceNS, intention.DestinationNS) {
+			logger.Warn("skipping intention between non-default namespaces as the target does not support namespaces")
+			continue
+		}
+
+		if len(intention.Meta) > 0 {
+			logger.Warn("dropping intention meta as service-intentions config entries only support meta on the destination")
+		}
+
+		if intention.DefaultAddr != "" || intention.DefaultPort != 0 {
+			logger.Warn("dropping unused default address and port from intention")
+		}
+
+		namespace := ""
+		sourceNS := ""
+		if imp.enterprise {
+			namespace = intention.DestinationNS
+			sourceNS = intention.SourceNS
+		}
+
+		key := namespace + "/" + intention.DestinationName
+		nsEntry, ok := entries[key]
+		if !ok {
+			nsEntry = &namespacedConfigEntry{
+				namespace: namespace,
+				entry: &api.ServiceIntentionsConfigEntry{
+					Kind: api.ServiceIntentions,
+					Name: intention.DestinationName,
+				},
+			}
+			entries[key] = nsEntry
+			order = append(order, key)
+		}
+
+		entry := nsEntry.entry.(*api.ServiceIntentionsConfigEntry)
+		entry.Sources = append(entry.Sources, &api.SourceIntention{
+			Name:        intention.SourceName,
+			Namespace:   sourceNS,
+			Action:      intention.Action,
+			Permissions: intention.Permissions,
+			Type:        intention.SourceType,
+			Description: intention.Description,
+		})
+	}
+
+	converted := make([]namespacedConfigEntry, 0, len(order))
+	for _, key := range order {
+		converted = append(converted, *entries[key])
+	}
+
+	return converted
+}
+
+// mergeIntentions merges converted service-intentions config entries with the entries the
+// target already has for the same destinations. Writing an entry replaces all of its
+// sources, so the sources which only exist on the target are carried over. A source on
+// both which differs is a conflict.
+func (imp *importer) mergeIntentions(entries []namespacedConfigEntry) error {
+	for i := range entries {
+		nsEntry := &entries[i]
+		key := checkpointKey(JournalConfigEntry, nsEntry.partition, nsEntry.namespace, nsEntry.entry.GetKind()+"/"+nsEntry.entry.GetName())
+		if imp.checkpoint.done(key) {
+			continue
+		}
+
+		previous, err := imp.readConfigEntry(nsEntry.entry, nsEntry.writeOptions(imp.opts))
+		if err != nil {
+			return fmt.Errorf("failed to look up config entry %s: %w", nsEntry, err)
+		}
+		existing, ok := previous.(*api.ServiceIntentionsConfigEntry)
+		if !ok {
+			continue
+		}
+
+		merged, err := imp.mergeIntentionSources(existing, nsEntry.entry.(*api.ServiceIntentionsConfigEntry))
+		if err != nil {
+			return err
+		}
+		if merged == nil {
+			// nothing to change so the entry of the target is written back as it is
+			nsEntry.entry = existing
+			continue
+		}
+		nsEntry.entry = merged
+		nsEntry.merged = true
+	}
+	return nil
+}
+
+// mergeIntentionSources merges the sources of an imported service-intentions config entry
+// into the existing entry, resolving the conflicts between the sources on both. It
+// returns nil when the existing entry already holds all of the imported sources.
+func (imp *importer) mergeIntentionSources(existing, imported *api.ServiceIntentionsConfigEntry) (*api.ServiceIntentionsConfigEntry, error) {
+	sourceKey := func(source *api.SourceIntention) string {
+		return orDefault(source.Partition) + "/" + orDefault(source.Namespace) + "/" + source.Name
+	}
+
+	importedSources := make(map[string]*api.SourceIntention, len(imported.Sources))
+	for _, source := range imported.Sources {
+		importedSources[sourceKey(source)] = source
+	}
+
+	merged := &api.ServiceIntentionsConfigEntry{
+		Kind: existing.Kind,
+		Name: existing.Name,
+		Meta: existing.Meta,
+	}
+	changed := false
+	for _, source := range existing.Sources {
+		key := sourceKey(source)
+		desired, ok := importedSources[key]
+		delete(importedSources, key)
+
+		if ok && !sourceIntentionMatches(source, desired) {
+			name := fmt.Sprintf("%s => %s", key, orDefault(existing.Namespace)+"/"+existing.Name)
+			overwrite, err := imp.resolveConflict("Intention", name)
+			if err != nil {
+				return nil, err
+			}
+			if overwrite {
+				merged.Sources = append(merged.Sources, desired)
+				changed = true
+				continue
+			}
+		}
+
+		// what the target computed or kept from legacy intentions cannot be written back
+		kept := *source
+		kept.Precedence = 0
+		kept.LegacyID = ""
+		kept.LegacyMeta = nil
+		kept.LegacyCreateTime = nil
+		kept.LegacyUpdateTime = nil
+		merged.Sources = append(merged.Sources, &kept)
+	}
+
+	for _, source := range imported.Sources {
+		if _, ok := importedSources[sourceKey(source)]; ok {
+			merged.Sources = append(merged.Sources, source)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+// sourceIntentionMatches reports whether an existing source of a service-intentions config
+// entry already is the desired source
+func sourceIntentionMatches(existing, desired *api.SourceIntention) bool {
+	if existing.Action != desired.Action || existing.Type != desired.Type || existing.Description != desired.Description {
+		return false
+	}
+	if len(existing.Permissions) == 0 && len(desired.Permissions) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(existing.Permissions, desired.Permissions)
+}
+
+// allNamespaces returns the query options to read from all namespaces at once
+func (imp *importer) allNamespaces() *api.QueryOptions {
+	if !imp.enterprise {
+		return nil
+	}
+	return &api.QueryOptions{Namespace: "*"}
+}
+
+func isDefaultNamespace(namespaces ...string) bool {
+	for _, ns := range namespaces {
+		if ns != "" && ns != api.IntentionDefaultNamespace {
+			return false
+		}
+	}
+	return true
+}
+
+// resetConfigEntry clears the raft indexes, partition and namespace of a config entry
+// so that it gets written into the partition and namespace given by the write options.
+// Every config entry type has these fields but the ConfigEntry interface provides no
+// setters for them.
+func resetConfigEntry(entry api.ConfigEntry) {
+	if raw, ok := entry.(RawConfigEntry); ok {
+		for _, name := range []string{"CreateIndex", "ModifyIndex", "Partition", "Namespace"} {
+			delete(raw, name)
+		}
+		return
+	}
+
+	v := reflect.ValueOf(entry).Elem()
+	for _, name := range []string{"CreateIndex", "ModifyIndex", "Partition", "Namespace"} {
+		if field := v.FieldByName(name); field.IsValid() && field.CanSet() {
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+}
+
+// hiddenToken is what Consul returns in place of a prepared query token when the
+// token used for the export was not allowed to see it.
+const hiddenToken = "<hidden>"
+
+func (imp *importer) importPreparedQueries(queries []api.PreparedQueryDefinition) error {
+	preparedQueries := imp.client.PreparedQuery()
+
+	existingQueries, _, err := preparedQueries.List(nil)
+	if err != nil {
+		return fmt.Errorf("failed to list prepared queries: %w", err)
+	}
+
+	for _, query := range queries {
+		queryID := query.ID
+		key := checkpointKey(JournalPreparedQuery, "", "", queryID)
+		if imp.alreadyImported(key) {
+			continue
+		}
+
+		query.ID = ""
+
+		logger := imp.logger.With("query", query.Name, "from", queryID)
+
+		if !imp.enterprise && !isDefaultNamespace(query.Service.Namespace) {
+			logger.Warn("skipping prepared query for a service in a non-default namespace as the target does not support namespaces")
+			continue
+		}
+		if !imp.enterprise {
+			query.Service.Namespace = ""
+		}
+
+		if query.Session != "" {
+			// sessions are not migrated and the query would be deleted along with its session
+			logger.Warn("removing session from prepared query", "session", query.Session)
+			query.Session = ""
+		}
+
+		switch {
+		case query.Token == "":
+		case query.Token == hiddenToken:
+			logger.Warn("removing prepared query token that was hidden from the exporting token")
+			query.Token = ""
+		default:
+			if secret, ok := imp.tokenSecretMap[query.Token]; ok {
+				query.Token = secret
+			} else {
+				logger.Warn("prepared query token was not migrated and may not exist on the target")
+			}
+		}
+
+		for i, dc := range query.Service.Failover.Datacenters {
+			query.Service.Failover.Datacenters[i] = imp.mapDatacenter(dc)
+		}
+
+		// templates are created as is: the name is the prefix to match and the
+		// regexp and any interpolations within the service query are left untouched
+		existing := findPreparedQuery(existingQueries, &query)
+		switch {
+		case existing == nil:
+			if err := imp.journalCreating(JournalPreparedQuery, nil, &query); err != nil {
+				return err
+			}
+			id, _, err := preparedQueries.Create(&query, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create prepared query %q: %w", query.Name, err)
+			}
+			if err := imp.journalCreate(scopedEntry(JournalPreparedQuery, id, nil)); err != nil {
+				return err
+			}
+			logger.Info("created Prepared Query", "id", id)
+		case preparedQueryMatches(existing, &query):
+			logger.Info("Prepared Query is up to date", "id", existing.ID)
+		default:
+			overwrite, err := imp.resolveConflict("Prepared Query", query.Name)
+			if err != nil {
+				return err
+			}
+			if !overwrite {
+				break
+			}
+
+			if err := imp.journalUpdate(scopedEntry(JournalPreparedQuery, existing.ID, nil), existing); err != nil {
+				return err
+			}
+			query.ID = existing.ID
+			if _, err := preparedQueries.Update(&query, nil); err != nil {
+				return fmt.Errorf("failed to update prepared query %q: %w", query.Name, err)
+			}
+			logger.Info("updated Prepared Query", "id", existing.ID)
+		}
+
+		if err := imp.checkpointed(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findPreparedQuery finds the query on the target which corresponds to an imported query.
+// Named queries are found by their name, which Consul keeps unique. Unnamed queries can
+// only be found by being identical.
+func findPreparedQuery(existing []*api.PreparedQueryDefinition, query *api.PreparedQueryDefinition) *api.PreparedQueryDefinition {
+	for _, candidate := range existing {
+		if query.Name != "" && candidate.Name == query.Name {
+			return candidate
+		}
+		if query.Name == "" && candidate.Name == "" && preparedQueryMatches(candidate, query) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// preparedQueryMatches reports whether an existing query already is the desired query
+func preparedQueryMatches(existing, desired *api.PreparedQueryDefinition) bool {
+	a, b := *existing, *desired
+	a.ID, b.ID = "", ""
+	return reflect.DeepEqual(a, b)
+}
+
+func (imp *importer) importCatalog(nodes []CatalogNode) error {
+	catalog := imp.client.Catalog()
+
+	for _, node := range nodes {
+		key := checkpointKey(JournalCatalogNode, "", "", node.Node.Node)
+		if imp.alreadyImported(key) {
+			continue
+		}
+
+		logger := imp.logger.With("node", node.Node.Node)
+
+		// services and checks from namespaces the target cannot hold
+		skippedServices := make(map[string]bool)
+		var services []api.AgentService
+		for _, service := range node.Services {
+			if !imp.enterprise && !isDefaultNamespace(service.Namespace) {
+				logger.Warn("skipping service in a non-default namespace as the target does not support namespaces", "service", service.ID, "service-ns", service.Namespace)
+				skippedServices[service.Namespace+"/"+service.ID] = true
+				continue
+			}
+
+			service.CreateIndex = 0
+			service.ModifyIndex = 0
+			service.ContentHash = ""
+			service.Datacenter = ""
+			if !imp.enterprise {
+				service.Namespace = ""
+			}
+			services = append(services, service)
+		}
+
+		var nodeChecks api.HealthChecks
+		serviceChecks := make(map[string]api.HealthChecks)
+		for _, check := range node.Checks {
+			if skippedServices[check.Namespace+"/"+check.ServiceID] {
+				continue
+			}
+
+			check := check
+			check.CreateIndex = 0
+			check.ModifyIndex = 0
+			if !imp.enterprise {
+				check.Namespace = ""
+			}
+
+			if check.ServiceID == "" {
+				nodeChecks = append(nodeChecks, &check)
+			} else {
+				key := check.Namespace + "/" + check.ServiceID
+				serviceChecks[key] = append(serviceChecks[key], &check)
+			}
+		}
+
+		current, _, err := catalog.Node(node.Node.Node, imp.allNamespaces())
+		if err != nil {
+			return fmt.Errorf("failed to look up node %s: %w", node.Node.Node, err)
+		}
+		var currentChecks api.HealthChecks
+		if current != nil && current.Node != nil {
+			currentChecks, _, err = imp.client.Health().Node(node.Node.Node, imp.allNamespaces())
+			if err != nil {
+				return fmt.Errorf("failed to look up the checks of node %s: %w", node.Node.Node, err)
+			}
+		}
+
+		nodeReg := api.CatalogRegistration{
+			ID:              node.Node.ID,
+			Node:            node.Node.Node,
+			Address:         node.Node.Address,
+			TaggedAddresses: node.Node.TaggedAddresses,
+			NodeMeta:        node.Node.Meta,
+			Checks:          nodeChecks,
+		}
+
+		register := true
+		if current != nil && current.Node != nil {
+			register = false
+			if !catalogNodeMatches(current.Node, &nodeReg, currentChecks) {
+				register, err = imp.resolveConflict("Catalog Node", node.Node.Node)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if register {
+			if err := imp.journalCatalogNode(node.Node.Node, current); err != nil {
+				return err
+			}
+			if _, err := catalog.Register(&nodeReg, nil); err != nil {
+				return fmt.Errorf("failed to register node %s: %w", node.Node.Node, err)
+			}
+
+			logger.Info("registered Catalog Node")
+		}
+
+		for _, service := range services {
+			service := service
+			serviceReg := api.CatalogRegistration{
+				Node:           node.Node.Node,
+				Address:        node.Node.Address,
+				Service:        &service,
+				Checks:         serviceChecks[service.Namespace+"/"+service.ID],
+				SkipNodeUpdate: true,
+			}
+
+			if existing := findCatalogService(current, &service); existing != nil {
+				register := false
+				if !catalogServiceMatches(existing, &service) ||
+					!sameStrings(catalogCheckKeys(currentChecks, service.ID), catalogCheckKeys(serviceReg.Checks, service.ID)) {
+					register, err = imp.resolveConflict("Catalog Service", node.Node.Node+"/"+service.ID)
+					if err != nil {
+						return err
+					}
+				}
+				if !register {
+					continue
+				}
+			}
+
+			if err := imp.journalCatalogService(node.Node.Node, &service, current); err != nil {
+				return err
+			}
+			if _, err := catalog.Register(&serviceReg, nil); err != nil {
+				return fmt.Errorf("failed to register service %s on node %s: %w", service.ID, node.Node.Node, err)
+			}
+
+			logger.Info("registered Catalog Service", "service", service.ID, "ns", service.Namespace)
+		}
+
+		if err := imp.checkpointed(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// journalCatalogNode records the registration of a node. Nodes which were not registered
+// before are recorded as created, which covers their services and checks as well.
+func (imp *importer) journalCatalogNode(name string, current *api.CatalogNode) error {
+	if current == nil || current.Node == nil {
+		return imp.journalCreate(scopedEntry(JournalCatalogNode, name, nil))
+	}
+	return imp.journalUpdate(scopedEntry(JournalCatalogNode, name, nil), current.Node)
+}
+
+// journalCatalogService records the registration of a service on a node which was
+// registered before the import
+func (imp *importer) journalCatalogService(node string, service *api.AgentService, current *api.CatalogNode) error {
+	if current == nil || current.Node == nil {
+		return nil
+	}
+
+	entry := scopedEntry(JournalCatalogService, service.ID, &api.WriteOptions{Namespace: service.Namespace})
+	entry.Node = node
+	if existing := findCatalogService(current, service); existing != nil {
+		return imp.journalUpdate(entry, existing)
+	}
+	return imp.journalCreate(entry)
+}
+
+// findCatalogService returns the service of a registered node with the same id and
+// namespace as the given service
+func findCatalogService(current *api.CatalogNode, service *api.AgentService) *api.AgentService {
+	if current == nil {
+		return nil
+	}
+	for _, existing := range current.Services {
+		if existing.ID == service.ID && (existing.Namespace == service.Namespace || isDefaultNamespace(existing.Namespace, service.Namespace)) {
+			return existing
+		}
+	}
+	return nil
+}
+
+// catalogNodeMatches reports whether a registered node already is the desired registration
+// along with its own checks
+func catalogNodeMatches(existing *api.Node, desired *api.CatalogRegistration, checks api.HealthChecks) bool {
+	return (desired.ID == "" || existing.ID == desired.ID) &&
+		existing.Address == desired.Address &&
+		sameMeta(existing.TaggedAddresses, desired.TaggedAddresses) &&
+		sameMeta(existing.Meta, desired.NodeMeta) &&
+		sameStrings(catalogCheckKeys(checks, ""), catalogCheckKeys(desired.Checks, ""))
+}
+
+// catalogServiceMatches reports whether a registered service already is the desired service.
+// Both are compared as JSON without what the target assigns itself.
+func catalogServiceMatches(existing, desired *api.AgentService) bool {
+	normalize := func(service api.AgentService) ([]byte, error) {
+		service.CreateIndex = 0
+		service.ModifyIndex = 0
+		service.ContentHash = ""
+		service.Datacenter = ""
+		service.Namespace = ""
+		service.Partition = ""
+		return json.Marshal(service)
+	}
+
+	existingJSON, err := normalize(*existing)
+	if err != nil {
+		return false
+	}
+	desiredJSON, err := normalize(*desired)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(existingJSON, desiredJSON)
+}
+
+// catalogCheckKeys describes the checks of a service, or of the node itself for an empty
+// service id, by what an import registers of them
+func catalogCheckKeys(checks api.HealthChecks, serviceID string) []string {
+	var keys []string
+	for _, check := range checks {
+		if check.ServiceID != serviceID {
+			continue
+		}
+		keys = append(keys, strings.Join([]string{check.CheckID, check.Name, check.Status, check.Notes, check.Output}, "\x00"))
+	}
+	return keys
+}
+
+func (imp *importer) importConnectCA(config *api.CAConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	if !imp.connectCA {
+		imp.logger.Warn("skipping the Connect CA configuration as it was not requested, setting it may rotate the CA of the target",
+			"provider", config.Provider)
+		return nil
+	}
+
+	connect := imp.client.Connect()
+
+	current, _, err := connect.CAGetConfig(nil)
+	if err != nil {
+		return fmt.Errorf("failed to read the Connect CA configuration: %w", err)
+	}
+
+	if current.Provider == config.Provider && reflect.DeepEqual(current.Config, config.Config) {
+		imp.logger.Info("Connect CA configuration is up to date", "provider", config.Provider)
+		return nil
+	}
+
+	if current.Provider != "" {
+		overwrite, err := imp.resolveConflict("Connect CA configuration", current.Provider)
+		if err != nil || !overwrite {
+			return err
+		}
+	}
+
+	roots, _, err := connect.CARoots(nil)
+	if err != nil {
+		return fmt.Errorf("failed to read the Connect CA roots: %w", err)
+	}
+
+	if roots.ActiveRootID != "" {
+		imp.logger.Warn("the target Connect CA is already issuing leaf certificates, setting the CA configuration will rotate the CA",
+			"active-root", roots.ActiveRootID, "provider", config.Provider)
+	}
+
+	config.CreateIndex = 0
+	config.ModifyIndex = 0
+	config.State = nil
+
+	if err := imp.journalUpdate(scopedEntry(JournalConnectCA, current.Provider, nil), current); err != nil {
+		return err
+	}
+	if _, err := connect.CASetConfig(config, nil); err != nil {
+		return fmt.Errorf("failed to set the Connect CA configuration: %w", err)
+	}
+
+	imp.logger.Info("updated Connect CA configuration", "provider", config.Provider)
+	return nil
+}
+
+// importKeyring installs the gossip encryption keys on the target and then makes the primary key
+// of the source the primary key of the target. Keys of the target are left installed so that
+// its agents can roll over.
+func (imp *importer) importKeyring(keyring *KeyringData) error {
+	if keyring == nil {
+		return nil
+	}
+
+	operator := imp.client.Operator()
+
+	rings, err := operator.KeyringList(nil)
+	if err != nil && strings.Contains(err.Error(), "encryption not enabled") {
+		imp.logger.Warn("skipping gossip encryption keys as the target does not have gossip encryption enabled")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error listing gossip encryption keys: %w", err)
+	}
+	current := lanKeyring(rings)
+
+	for _, key := range keyring.Keys {
+		if current != nil && current.Keys[key] == 0 {
+			if err := imp.journalCreate(scopedEntry(JournalGossipKey, key, nil)); err != nil {
+				return err
+			}
+		}
+
+		err := operator.KeyringInstall(key, nil)
+		if err != nil && strings.Contains(err.Error(), "encryption not enabled") {
+			imp.logger.Warn("skipping gossip encryption keys as the target does not have gossip encryption enabled")
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error installing gossip encryption key: %w", err)
+		}
+	}
+	imp.logger.Info("installed gossip encryption keys", "keys", len(keyring.Keys))
+
+	if keyring.PrimaryKey == "" {
+		return nil
+	}
+
+	if current != nil {
+		previous, _ := primaryKey(current)
+		if previous == keyring.PrimaryKey {
+			imp.logger.Info("the primary gossip encryption key is already up to date")
+			return nil
+		}
+		if previous != "" {
+			overwrite, err := imp.resolveConflict("Gossip encryption", "primary key")
+			if err != nil || !overwrite {
+				return err
+			}
+		}
+		if err := imp.journalUpdate(scopedEntry(JournalGossipPrimaryKey, keyring.PrimaryKey, nil), previous); err != nil {
+			return err
+		}
+	}
+	if err := operator.KeyringUse(keyring.PrimaryKey, nil); err != nil {
+		return fmt.Errorf("error changing the primary gossip encryption key: %w", err)
+	}
+
+	imp.logger.Info("changed the primary gossip encryption key")
+	return nil
+}
+
+func (imp *importer) importOperatorData(operator *OperatorData) error {
+	if operator == nil {
+		return nil
+	}
+
+	err := imp.once(checkpointKey(JournalAutopilot, "", "", ""), func() error {
+		return imp.importAutopilot(operator.Autopilot)
+	})
+	if err != nil {
+		return err
+	}
+
+	return imp.importNetworkAreas(operator.NetworkAreas)
+}
+
+func (imp *importer) importNetworkAreas(areas []api.Area) error {
+	if len(areas) > 0 && !imp.enterprise {
+		imp.logger.Warn("skipping network areas as the target does not support them", "count", len(areas))
+		return nil
+	}
+
+	op := imp.client.Operator()
+
+	existingAreas, _, err := op.AreaList(nil)
+	if err != nil {
+		return fmt.Errorf("failed to list network areas: %w", err)
+	}
+
+	// there is at most one area per peer datacenter
+	byPeer := make(map[string]*api.Area)
+	for _, area := range existingAreas {
+		byPeer[area.PeerDatacenter] = area
+	}
+
+	for _, area := range areas {
+		areaID := area.ID
+		key := checkpointKey(JournalNetworkArea, "", "", areaID)
+		if imp.alreadyImported(key) {
+			continue
+		}
+
+		area.ID = ""
+		area.PeerDatacenter = imp.mapDatacenter(area.PeerDatacenter)
+
+		existing := byPeer[area.PeerDatacenter]
+		switch {
+		case existing == nil:
+			if err := imp.journalCreating(JournalNetworkArea, nil, &area); err != nil {
+				return err
+			}
+			id, _, err := op.AreaCreate(&area, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create network area with peer datacenter %s: %w", area.PeerDatacenter, err)
+			}
+			if err := imp.journalCreate(scopedEntry(JournalNetworkArea, id, nil)); err != nil {
+				return err
+			}
+			imp.logger.Info("created Network Area", "id", id, "from", areaID, "peer-datacenter", area.PeerDatacenter)
+		case existing.UseTLS == area.UseTLS && sameStrings(existing.RetryJoin, area.RetryJoin):
+			imp.logger.Info("Network Area is up to date", "id", existing.ID, "from", areaID, "peer-datacenter", area.PeerDatacenter)
+		default:
+			overwrite, err := imp.resolveConflict("Network Area", area.PeerDatacenter)
+			if err != nil {
+				return err
+			}
+			if !overwrite {
+				break
+			}
+
+			if err := imp.journalUpdate(scopedEntry(JournalNetworkArea, existing.ID, nil), existing); err != nil {
+				return err
+			}
+			if _, _, err := op.AreaUpdate(existing.ID, &area, nil); err != nil {
+				return fmt.Errorf("failed to update network area with peer datacenter %s: %w", area.PeerDatacenter, err)
+			}
+			imp.logger.Info("updated Network Area", "id", existing.ID, "from", areaID, "peer-datacenter", area.PeerDatacenter)
+		}
+
+		if err := imp.checkpointed(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (imp *importer) importAutopilot(config *api.AutopilotConfiguration) error {
+	if config == nil {
+		return nil
+	}
+
+	op := imp.client.Operator()
+
+	current, err := op.AutopilotGetConfiguration(nil)
+	if err != nil {
+		return fmt.Errorf("failed to read the current autopilot configuration: %w", err)
+	}
+
+	changes := diffAutopilotConfig(current, config)
+	if len(changes) == 0 {
+		imp.logger.Info("autopilot configuration is already up to date")
+		return nil
+	}
+
+	overwrite, err := imp.resolveConflict("Autopilot", "configuration")
+	if err != nil || !overwrite {
+		return err
+	}
+
+	for _, change := range changes {
+		imp.logger.Info("changing autopilot setting", "setting", change.name, "from", change.from, "to", change.to)
+	}
+
+	config.CreateIndex = 0
+	config.ModifyIndex = 0
+	if err := imp.journalUpdate(scopedEntry(JournalAutopilot, "", nil), current); err != nil {
+		return err
+	}
+	if err := op.AutopilotSetConfiguration(config, nil); err != nil {
+		return fmt.Errorf("failed to set the autopilot configuration: %w", err)
+	}
+
+	imp.logger.Info("updated autopilot configuration")
+	return nil
+}
+
+// settingChange is the change of a single setting from one value to another
+type settingChange struct {
+	name string
+	from string
+	to   string
+}
+
+func diffAutopilotConfig(current, desired *api.AutopilotConfiguration) []settingChange {
+	settings := []settingChange{
+		{"CleanupDeadServers", fmt.Sprint(current.CleanupDeadServers), fmt.Sprint(desired.CleanupDeadServers)},
+		{"LastContactThreshold", current.LastContactThreshold.String(), desired.LastContactThreshold.String()},
+		{"MaxTrailingLogs", fmt.Sprint(current.MaxTrailingLogs), fmt.Sprint(desired.MaxTrailingLogs)},
+		{"MinQuorum", fmt.Sprint(current.MinQuorum), fmt.Sprint(desired.MinQuorum)},
+		{"ServerStabilizationTime", current.ServerStabilizationTime.String(), desired.ServerStabilizationTime.String()},
+		{"RedundancyZoneTag", current.RedundancyZoneTag, desired.RedundancyZoneTag},
+		{"DisableUpgradeMigration", fmt.Sprint(current.DisableUpgradeMigration), fmt.Sprint(desired.DisableUpgradeMigration)},
+		{"UpgradeVersionTag", current.UpgradeVersionTag, desired.UpgradeVersionTag},
+	}
+
+	var changes []settingChange
+	for _, setting := range settings {
+		if setting.from != setting.to {
+			changes = append(changes, setting)
+		}
+	}
+	return changes
 }
@@ -0,0 +1,745 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestImportIntentions_UpdatesChangedAction(t *testing.T) {
+	existing := []*api.Intention{{
+		ID:              "existing-id",
+		SourceNS:        "default",
+		SourceName:      "web",
+		DestinationNS:   "default",
+		DestinationName: "db",
+		SourceType:      api.IntentionSourceConsul,
+		Action:          api.IntentionActionAllow,
+	}}
+
+	var created, updated []api.Intention
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/config/service-intentions", func(w http.ResponseWriter, r *http.Request) {
+		// a target that predates service-intentions config entries
+		http.Error(w, "invalid config entry kind: service-intentions", http.StatusBadRequest)
+	})
+	mux.HandleFunc("/v1/connect/intentions", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(existing)
+		case http.MethodPost:
+			var intention api.Intention
+			json.NewDecoder(r.Body).Decode(&intention)
+			created = append(created, intention)
+			json.NewEncoder(w).Encode(map[string]string{"ID": "new-id"})
+		}
+	})
+	mux.HandleFunc("/v1/connect/intentions/existing-id", func(w http.ResponseWriter, r *http.Request) {
+		var intention api.Intention
+		json.NewDecoder(r.Body).Decode(&intention)
+		updated = append(updated, intention)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imp := &importer{client: client, logger: hclog.NewNullLogger()}
+	entries, err := imp.importIntentions([]api.Intention{{
+		ID:              "source-id",
+		SourceName:      "web",
+		DestinationName: "db",
+		SourceType:      api.IntentionSourceConsul,
+		Action:          api.IntentionActionDeny,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no config entries, got %d", len(entries))
+	}
+
+	if len(created) != 0 {
+		t.Fatalf("expected no intention to be created, got %d", len(created))
+	}
+	if len(updated) != 1 {
+		t.Fatalf("expected the existing intention to be updated, got %d updates", len(updated))
+	}
+	if updated[0].Action != api.IntentionActionDeny {
+		t.Fatalf("expected the action to be updated to deny, got %q", updated[0].Action)
+	}
+}
+
+func TestFindIntention(t *testing.T) {
+	existing := []*api.Intention{
+		{ID: "1", SourceNS: "default", SourceName: "web", DestinationNS: "default", DestinationName: "db", Action: api.IntentionActionAllow},
+		{ID: "2", SourceNS: "team", SourceName: "web", DestinationNS: "default", DestinationName: "db", Action: api.IntentionActionAllow},
+	}
+
+	cases := map[string]struct {
+		intention api.Intention
+		expected  string
+	}{
+		"only the action differs": {
+			intention: api.Intention{SourceName: "web", DestinationName: "db", Action: api.IntentionActionDeny},
+			expected:  "1",
+		},
+		"different source namespace": {
+			intention: api.Intention{SourceNS: "team", SourceName: "web", DestinationName: "db"},
+			expected:  "2",
+		},
+		"different destination": {
+			intention: api.Intention{SourceName: "web", DestinationName: "cache"},
+		},
+		"different source partition": {
+			intention: api.Intention{SourcePartition: "other", SourceName: "web", DestinationName: "db"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			found := findIntention(existing, &tc.intention)
+			switch {
+			case tc.expected == "" && found != nil:
+				t.Fatalf("expected no match, got intention %s", found.ID)
+			case tc.expected != "" && found == nil:
+				t.Fatalf("expected intention %s, got no match", tc.expected)
+			case tc.expected != "" && found.ID != tc.expected:
+				t.Fatalf("expected intention %s, got %s", tc.expected, found.ID)
+			}
+		})
+	}
+}
+
+func TestUpsertPolicy_RenameSkipsConflictingRenamedPolicy(t *testing.T) {
+	existing := map[string]*api.ACLPolicy{
+		"web":          {ID: "web-id", Name: "web", Rules: `node_prefix "" { policy = "read" }`},
+		"web-migrated": {ID: "renamed-id", Name: "web-migrated", Rules: `node_prefix "" { policy = "write" }`},
+	}
+
+	var created, updated []api.ACLPolicy
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/acl/policy/name/", func(w http.ResponseWriter, r *http.Request) {
+		policy, ok := existing[r.URL.Path[len("/v1/acl/policy/name/"):]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(policy)
+	})
+	mux.HandleFunc("/v1/acl/policy", func(w http.ResponseWriter, r *http.Request) {
+		var policy api.ACLPolicy
+		json.NewDecoder(r.Body).Decode(&policy)
+		created = append(created, policy)
+		policy.ID = "new-id"
+		json.NewEncoder(w).Encode(policy)
+	})
+	mux.HandleFunc("/v1/acl/policy/", func(w http.ResponseWriter, r *http.Request) {
+		var policy api.ACLPolicy
+		json.NewDecoder(r.Body).Decode(&policy)
+		updated = append(updated, policy)
+		json.NewEncoder(w).Encode(policy)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imp := &importer{client: client, logger: hclog.NewNullLogger(), onConflict: ConflictRename, renameSuffix: "-migrated"}
+	policy := &api.ACLPolicy{Name: "web", Rules: `service_prefix "" { policy = "read" }`}
+	id, err := imp.upsertPolicy(policy, "source-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(updated) != 0 {
+		t.Fatalf("expected no policy of the target to be overwritten, got %+v", updated)
+	}
+	if len(created) != 1 || created[0].Name != "web-migrated-2" {
+		t.Fatalf("expected the policy to be created as web-migrated-2, got %+v", created)
+	}
+	if id != "new-id" {
+		t.Fatalf("expected the id of the created policy, got %q", id)
+	}
+
+	// once a renamed policy matches it is kept
+	existing["web-migrated-2"] = &api.ACLPolicy{ID: "new-id", Name: "web-migrated-2", Rules: policy.Rules}
+	created = nil
+	policy = &api.ACLPolicy{Name: "web", Rules: `service_prefix "" { policy = "read" }`}
+	if id, err = imp.upsertPolicy(policy, "source-id"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(created) != 0 || len(updated) != 0 || id != "new-id" {
+		t.Fatalf("expected the matching renamed policy to be kept, got id %q, created %+v, updated %+v", id, created, updated)
+	}
+}
+
+func TestPrepareToken_Expiration(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	expiring := time.Now().Add(time.Second)
+	valid := time.Now().Add(time.Hour)
+
+	cases := map[string]struct {
+		token    api.ACLToken
+		expected bool
+	}{
+		"without expiration": {
+			token:    api.ACLToken{AccessorID: "forever"},
+			expected: true,
+		},
+		"expired": {
+			token: api.ACLToken{AccessorID: "expired", ExpirationTime: &expired},
+		},
+		"about to expire": {
+			token: api.ACLToken{AccessorID: "expiring", ExpirationTime: &expiring},
+		},
+		"valid": {
+			token:    api.ACLToken{AccessorID: "valid", ExpirationTime: &valid, ExpirationTTL: 2 * time.Hour},
+			expected: true,
+		},
+		"recreated from an auth method": {
+			token:    api.ACLToken{AccessorID: "login", AuthMethod: "kubernetes", ExpirationTime: &valid},
+			expected: true,
+		},
+		"expired from an auth method": {
+			token: api.ACLToken{AccessorID: "login", AuthMethod: "kubernetes", ExpirationTime: &expired},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			imp := &importer{logger: hclog.NewNullLogger(), authMethodTokens: AuthMethodTokensRecreate}
+			ok, err := imp.prepareToken(&tc.token)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tc.expected {
+				t.Fatalf("expected the token to be imported: %v, got %v", tc.expected, ok)
+			}
+			if ok && tc.token.ExpirationTTL != 0 {
+				t.Fatalf("expected only the expiration time to be kept, got a ttl of %s", tc.token.ExpirationTTL)
+			}
+		})
+	}
+}
+
+func TestCheckTokenLocality(t *testing.T) {
+	cases := map[string]struct {
+		targetDC  string
+		primaryDC string
+		sourceDC  string
+		dcMap     map[string]string
+		token     api.ACLToken
+		expected  bool
+	}{
+		"global token into the primary": {
+			targetDC: "dc1", primaryDC: "dc1", sourceDC: "dc1",
+			token:    api.ACLToken{AccessorID: "global"},
+			expected: true,
+		},
+		"global token into a secondary": {
+			targetDC: "dc2", primaryDC: "dc1", sourceDC: "dc2",
+			token: api.ACLToken{AccessorID: "global"},
+		},
+		"global token without a primary": {
+			targetDC: "dc2", sourceDC: "dc1",
+			token:    api.ACLToken{AccessorID: "global"},
+			expected: true,
+		},
+		"local token into a secondary it was exported from": {
+			targetDC: "dc2", primaryDC: "dc1", sourceDC: "dc2",
+			token:    api.ACLToken{AccessorID: "local", Local: true},
+			expected: true,
+		},
+		"local token into another datacenter": {
+			targetDC: "dc1", primaryDC: "dc1", sourceDC: "dc2",
+			token: api.ACLToken{AccessorID: "local", Local: true},
+		},
+		"local token into the datacenter it is mapped to": {
+			targetDC: "east", primaryDC: "east", sourceDC: "dc1",
+			dcMap:    map[string]string{"dc1": "east"},
+			token:    api.ACLToken{AccessorID: "local", Local: true},
+			expected: true,
+		},
+		"local token from data without a datacenter": {
+			targetDC: "dc1", primaryDC: "dc1",
+			token:    api.ACLToken{AccessorID: "local", Local: true},
+			expected: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]map[string]interface{}{
+					"Config": {"Datacenter": tc.targetDC, "PrimaryDatacenter": tc.primaryDC},
+				})
+			}))
+			defer server.Close()
+
+			client, err := api.NewClient(&api.Config{Address: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			imp := &importer{client: client, logger: hclog.NewNullLogger(), dcMap: tc.dcMap}
+			data := &Data{Datacenter: tc.sourceDC}
+			data.ACLTokens = map[string]api.ACLToken{tc.token.AccessorID: tc.token}
+			if err := imp.checkTokenLocality(data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ok, err := imp.prepareToken(&tc.token)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tc.expected {
+				t.Fatalf("expected the token to be imported: %v, got %v", tc.expected, ok)
+			}
+		})
+	}
+}
+
+func TestMapScopedDatacenters(t *testing.T) {
+	imp := &importer{
+		dcMap:       map[string]string{"dc1": "east"},
+		datacenters: map[string]bool{"east": true, "dc2": true},
+	}
+
+	cases := map[string]struct {
+		dcs      []string
+		expected []string
+		warnings int
+	}{
+		"unscoped": {},
+		"mapped": {
+			dcs:      []string{"dc1", "dc2"},
+			expected: []string{"east", "dc2"},
+		},
+		"unknown to the target": {
+			dcs:      []string{"dc1", "dc3"},
+			expected: []string{"east", "dc3"},
+			warnings: 1,
+		},
+		"already the name on the target": {
+			dcs:      []string{"east"},
+			expected: []string{"east"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger := hclog.New(&hclog.LoggerOptions{Output: &out})
+
+			mapped := imp.mapScopedDatacenters(tc.dcs, logger)
+			if !reflect.DeepEqual(mapped, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, mapped)
+			}
+			if warnings := strings.Count(out.String(), "[WARN]"); warnings != tc.warnings {
+				t.Fatalf("expected %d warnings, got %d: %s", tc.warnings, warnings, out.String())
+			}
+		})
+	}
+}
+
+func TestImportKV_OnConflict(t *testing.T) {
+	cases := map[ConflictMode]struct {
+		written []string
+		err     bool
+	}{
+		ConflictOverwrite: {written: []string{"new", "changed"}},
+		ConflictSkip:      {written: []string{"new"}},
+		ConflictRename:    {written: []string{"new"}},
+		ConflictFail:      {err: true},
+	}
+
+	for mode, tc := range cases {
+		t.Run(string(mode), func(t *testing.T) {
+			existing := map[string]string{"same": "value", "changed": "old"}
+
+			var written []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				key := r.URL.Path[len("/v1/kv/"):]
+				switch r.Method {
+				case http.MethodGet:
+					value, ok := existing[key]
+					if !ok {
+						http.NotFound(w, r)
+						return
+					}
+					json.NewEncoder(w).Encode(api.KVPairs{{Key: key, Value: []byte(value)}})
+				case http.MethodPut:
+					written = append(written, key)
+					w.Write([]byte("true"))
+				}
+			}))
+			defer server.Close()
+
+			client, err := api.NewClient(&api.Config{Address: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			imp := &importer{client: client, logger: hclog.NewNullLogger(), onConflict: mode}
+			err = imp.importKV(api.KVPairs{
+				{Key: "same", Value: []byte("value")},
+				{Key: "new", Value: []byte("value")},
+				{Key: "changed", Value: []byte("new")},
+			})
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected the conflict to fail the import")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(written, tc.written) {
+				t.Fatalf("expected %v to be written, got %v", tc.written, written)
+			}
+		})
+	}
+}
+
+func TestImportConfigEntries_OnConflict(t *testing.T) {
+	cases := map[ConflictMode]struct {
+		written []string
+		err     bool
+	}{
+		ConflictOverwrite: {written: []string{"new", "changed"}},
+		ConflictSkip:      {written: []string{"new"}},
+		ConflictFail:      {err: true},
+	}
+
+	for mode, tc := range cases {
+		t.Run(string(mode), func(t *testing.T) {
+			existing := map[string]*api.ServiceConfigEntry{
+				"same":    {Kind: api.ServiceDefaults, Name: "same", Protocol: "http", CreateIndex: 5, ModifyIndex: 6},
+				"changed": {Kind: api.ServiceDefaults, Name: "changed", Protocol: "tcp", CreateIndex: 5, ModifyIndex: 6},
+			}
+
+			var written []string
+			mux := http.NewServeMux()
+			mux.HandleFunc("/v1/config/service-defaults/", func(w http.ResponseWriter, r *http.Request) {
+				entry, ok := existing[r.URL.Path[len("/v1/config/service-defaults/"):]]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewEncoder(w).Encode(entry)
+			})
+			mux.HandleFunc("/v1/config", func(w http.ResponseWriter, r *http.Request) {
+				var entry api.ServiceConfigEntry
+				json.NewDecoder(r.Body).Decode(&entry)
+				written = append(written, entry.Name)
+				w.Write([]byte("true"))
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			client, err := api.NewClient(&api.Config{Address: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			imp := &importer{client: client, logger: hclog.NewNullLogger(), onConflict: mode}
+			err = imp.importConfigEntries(namespacedConfigEntries("", "", ConfigEntries{
+				&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "same", Protocol: "http", CreateIndex: 1},
+				&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "new", Protocol: "http"},
+				&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "changed", Protocol: "http"},
+			}))
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected the conflict to fail the import")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(written, tc.written) {
+				t.Fatalf("expected %v to be written, got %v", tc.written, written)
+			}
+		})
+	}
+}
+
+func TestConvertIntentions(t *testing.T) {
+	cases := map[string]struct {
+		enterprise bool
+		intentions []api.Intention
+		expected   []namespacedConfigEntry
+		warnings   int
+	}{
+		"legacy precedence": {
+			// the precedence of the legacy intentions follows from the names, which are kept
+			intentions: []api.Intention{
+				{SourceName: "*", DestinationName: "db", Action: api.IntentionActionDeny, SourceType: api.IntentionSourceConsul, Precedence: 8},
+				{SourceName: "web", DestinationName: "db", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul, Precedence: 9, Description: "web reads"},
+			},
+			expected: []namespacedConfigEntry{{entry: &api.ServiceIntentionsConfigEntry{
+				Kind: api.ServiceIntentions,
+				Name: "db",
+				Sources: []*api.SourceIntention{
+					{Name: "*", Action: api.IntentionActionDeny, Type: api.IntentionSourceConsul},
+					{Name: "web", Action: api.IntentionActionAllow, Type: api.IntentionSourceConsul, Description: "web reads"},
+				},
+			}}},
+		},
+		"wildcard destination": {
+			intentions: []api.Intention{
+				{SourceName: "*", DestinationName: "*", Action: api.IntentionActionDeny, SourceType: api.IntentionSourceConsul},
+				{SourceName: "web", DestinationName: "db", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul},
+			},
+			expected: []namespacedConfigEntry{
+				{entry: &api.ServiceIntentionsConfigEntry{
+					Kind:    api.ServiceIntentions,
+					Name:    "*",
+					Sources: []*api.SourceIntention{{Name: "*", Action: api.IntentionActionDeny, Type: api.IntentionSourceConsul}},
+				}},
+				{entry: &api.ServiceIntentionsConfigEntry{
+					Kind:    api.ServiceIntentions,
+					Name:    "db",
+					Sources: []*api.SourceIntention{{Name: "web", Action: api.IntentionActionAllow, Type: api.IntentionSourceConsul}},
+				}},
+			},
+		},
+		"namespaces": {
+			enterprise: true,
+			intentions: []api.Intention{
+				{SourceNS: "team", SourceName: "web", DestinationNS: "data", DestinationName: "db", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul},
+				{SourceNS: "*", SourceName: "*", DestinationNS: "data", DestinationName: "db", Action: api.IntentionActionDeny, SourceType: api.IntentionSourceConsul},
+				{SourceNS: "team", SourceName: "web", DestinationNS: "team", DestinationName: "db", Action: api.IntentionActionDeny, SourceType: api.IntentionSourceConsul},
+			},
+			expected: []namespacedConfigEntry{
+				{namespace: "data", entry: &api.ServiceIntentionsConfigEntry{
+					Kind: api.ServiceIntentions,
+					Name: "db",
+					Sources: []*api.SourceIntention{
+						{Name: "web", Namespace: "team", Action: api.IntentionActionAllow, Type: api.IntentionSourceConsul},
+						{Name: "*", Namespace: "*", Action: api.IntentionActionDeny, Type: api.IntentionSourceConsul},
+					},
+				}},
+				{namespace: "team", entry: &api.ServiceIntentionsConfigEntry{
+					Kind:    api.ServiceIntentions,
+					Name:    "db",
+					Sources: []*api.SourceIntention{{Name: "web", Namespace: "team", Action: api.IntentionActionDeny, Type: api.IntentionSourceConsul}},
+				}},
+			},
+		},
+		"namespaces into oss": {
+			intentions: []api.Intention{
+				{SourceNS: "team", SourceName: "web", DestinationNS: "default", DestinationName: "db", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul},
+				{SourceNS: "default", SourceName: "api", DestinationNS: "default", DestinationName: "db", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul},
+			},
+			expected: []namespacedConfigEntry{{entry: &api.ServiceIntentionsConfigEntry{
+				Kind:    api.ServiceIntentions,
+				Name:    "db",
+				Sources: []*api.SourceIntention{{Name: "api", Action: api.IntentionActionAllow, Type: api.IntentionSourceConsul}},
+			}}},
+			warnings: 1,
+		},
+		"meta and default address": {
+			intentions: []api.Intention{
+				{SourceName: "web", DestinationName: "db", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul, Meta: map[string]string{"owner": "web"}},
+				{SourceName: "api", DestinationName: "db", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul, DefaultAddr: "10.0.0.1", DefaultPort: 8080},
+			},
+			expected: []namespacedConfigEntry{{entry: &api.ServiceIntentionsConfigEntry{
+				Kind: api.ServiceIntentions,
+				Name: "db",
+				Sources: []*api.SourceIntention{
+					{Name: "web", Action: api.IntentionActionAllow, Type: api.IntentionSourceConsul},
+					{Name: "api", Action: api.IntentionActionAllow, Type: api.IntentionSourceConsul},
+				},
+			}}},
+			warnings: 2,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			imp := &importer{logger: hclog.New(&hclog.LoggerOptions{Output: &out}), enterprise: tc.enterprise}
+
+			converted := imp.convertIntentions(tc.intentions)
+			if !reflect.DeepEqual(converted, tc.expected) {
+				got, _ := json.Marshal(converted)
+				t.Fatalf("unexpected config entries: %s", got)
+			}
+			if warnings := strings.Count(out.String(), "[WARN]"); warnings != tc.warnings {
+				t.Fatalf("expected %d warnings, got %d: %s", tc.warnings, warnings, out.String())
+			}
+		})
+	}
+}
+
+func TestMergeIntentions(t *testing.T) {
+	createTime := time.Now()
+	existing := &api.ServiceIntentionsConfigEntry{
+		Kind: api.ServiceIntentions,
+		Name: "db",
+		Meta: map[string]string{"owner": "data"},
+		Sources: []*api.SourceIntention{
+			{Name: "cache", Action: api.IntentionActionAllow, Type: api.IntentionSourceConsul, Precedence: 9, LegacyID: "legacy-id", LegacyCreateTime: &createTime},
+			{Name: "web", Action: api.IntentionActionDeny, Type: api.IntentionSourceConsul, Precedence: 9},
+		},
+		CreateIndex: 10,
+		ModifyIndex: 11,
+	}
+
+	cases := map[ConflictMode]struct {
+		sources []string
+		err     bool
+	}{
+		ConflictOverwrite: {sources: []string{"cache allow", "web allow", "api allow"}},
+		ConflictSkip:      {sources: []string{"cache allow", "web deny", "api allow"}},
+		ConflictFail:      {err: true},
+	}
+
+	for mode, tc := range cases {
+		t.Run(string(mode), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/config/service-intentions/db" {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewEncoder(w).Encode(existing)
+			}))
+			defer server.Close()
+
+			client, err := api.NewClient(&api.Config{Address: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			imp := &importer{client: client, logger: hclog.NewNullLogger(), onConflict: mode}
+			entries := imp.convertIntentions([]api.Intention{
+				{SourceName: "web", DestinationName: "db", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul},
+				{SourceName: "api", DestinationName: "db", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul},
+				{SourceName: "web", DestinationName: "cache", Action: api.IntentionActionAllow, SourceType: api.IntentionSourceConsul},
+			})
+			err = imp.mergeIntentions(entries)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected the conflicting source to fail the import")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !entries[0].merged || entries[1].merged {
+				t.Fatalf("expected only the entry of db to be merged, got %+v", entries)
+			}
+			merged := entries[0].entry.(*api.ServiceIntentionsConfigEntry)
+			var sources []string
+			for _, source := range merged.Sources {
+				sources = append(sources, source.Name+" "+string(source.Action))
+				if source.Precedence != 0 || source.LegacyID != "" || source.LegacyCreateTime != nil {
+					t.Fatalf("expected the fields the target assigns to be cleared, got %+v", source)
+				}
+			}
+			if !reflect.DeepEqual(sources, tc.sources) {
+				t.Fatalf("expected sources %v, got %v", tc.sources, sources)
+			}
+			if merged.Meta["owner"] != "data" {
+				t.Fatalf("expected the meta of the target to be kept, got %v", merged.Meta)
+			}
+		})
+	}
+}
+
+func TestConfigEntryOrder(t *testing.T) {
+	// each kind has to be written after the kinds before it
+	ordered := [][]string{
+		{api.ProxyDefaults, api.MeshConfig},
+		{api.ServiceDefaults},
+		{api.ServiceResolver},
+		{api.ServiceSplitter},
+		{api.ServiceRouter},
+		{api.IngressGateway, api.TerminatingGateway},
+		{api.ServiceIntentions},
+		{api.ExportedServices, "inline-certificate"},
+		{"api-gateway"},
+		{"http-route", "tcp-route"},
+		{"some-future-kind"},
+	}
+
+	for i, kinds := range ordered {
+		for _, kind := range kinds {
+			if order := configEntryOrder(kind); order != configEntryOrder(kinds[0]) {
+				t.Fatalf("expected %s to be written along with %s, got %d and %d", kind, kinds[0], order, configEntryOrder(kinds[0]))
+			}
+			if i > 0 && configEntryOrder(kind) <= configEntryOrder(ordered[i-1][0]) {
+				t.Fatalf("expected %s to be written after %s", kind, ordered[i-1][0])
+			}
+		}
+	}
+}
+
+func TestConfigEntryDependents(t *testing.T) {
+	remaining := []namespacedConfigEntry{
+		{entry: &api.ServiceResolverConfigEntry{Kind: api.ServiceResolver, Name: "web"}},
+		{entry: &api.ServiceResolverConfigEntry{Kind: api.ServiceResolver, Name: "db"}},
+		{namespace: "team", entry: &api.ServiceSplitterConfigEntry{Kind: api.ServiceSplitter, Name: "web"}},
+		{entry: &api.ServiceRouterConfigEntry{Kind: api.ServiceRouter, Name: "web"}},
+		{entry: &api.IngressGatewayConfigEntry{Kind: api.IngressGateway, Name: "ingress", Listeners: []api.IngressListener{
+			{Services: []api.IngressService{{Name: "db"}, {Name: "web"}}},
+		}}},
+		{entry: &api.IngressGatewayConfigEntry{Kind: api.IngressGateway, Name: "other-ns", Listeners: []api.IngressListener{
+			{Services: []api.IngressService{{Name: "web", Namespace: "team"}}},
+		}}},
+		{entry: &api.TerminatingGatewayConfigEntry{Kind: api.TerminatingGateway, Name: "terminating", Services: []api.LinkedService{{Name: "web"}}}},
+		{entry: &api.ServiceIntentionsConfigEntry{Kind: api.ServiceIntentions, Name: "web"}},
+	}
+
+	cases := map[string]struct {
+		failed   namespacedConfigEntry
+		expected []string
+	}{
+		"service defaults": {
+			failed:   namespacedConfigEntry{entry: &api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "web"}},
+			expected: []string{"service-resolver/web", "service-router/web", "ingress-gateway/ingress", "terminating-gateway/terminating"},
+		},
+		"resolver in a namespace": {
+			failed:   namespacedConfigEntry{namespace: "team", entry: &api.ServiceResolverConfigEntry{Kind: api.ServiceResolver, Name: "web"}},
+			expected: []string{"service-splitter/web (ns: team)", "ingress-gateway/other-ns"},
+		},
+		"router": {
+			failed:   namespacedConfigEntry{entry: &api.ServiceRouterConfigEntry{Kind: api.ServiceRouter, Name: "web"}},
+			expected: []string{"ingress-gateway/ingress", "terminating-gateway/terminating"},
+		},
+		"nothing references it": {
+			failed: namespacedConfigEntry{entry: &api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "cache"}},
+		},
+		"outside the discovery chain": {
+			failed: namespacedConfigEntry{entry: &api.ProxyConfigEntry{Kind: api.ProxyDefaults, Name: api.ProxyConfigGlobal}},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dependents := configEntryDependents(tc.failed, remaining)
+			if !reflect.DeepEqual(dependents, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, dependents)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// JournalOp is what an import did to an object. Each entry is recorded before the
+// import writes the object, so a journal may hold changes which never happened when the
+// import failed during the write. Rolling them back finds nothing to delete or restores
+// the state the object still has.
+type JournalOp string
+
+const (
+	// JournalCreate is an object the import created
+	JournalCreate JournalOp = "create"
+	// JournalUpdate is an object the import changed. The entry holds
+	// the object as it was before.
+	JournalUpdate JournalOp = "update"
+)
+
+// The kinds of objects recorded in a journal
+const (
+	JournalPartition        = "partition"
+	JournalNamespace        = "namespace"
+	JournalACLPolicy        = "acl-policy"
+	JournalACLRole          = "acl-role"
+	JournalACLAuthMethod    = "acl-auth-method"
+	JournalACLBindingRule   = "acl-binding-rule"
+	JournalACLToken         = "acl-token"
+	JournalKV               = "kv"
+	JournalConfigEntry      = "config-entry"
+	JournalIntention        = "intention"
+	JournalPreparedQuery    = "prepared-query"
+	JournalCatalogNode      = "catalog-node"
+	JournalCatalogService   = "catalog-service"
+	JournalConnectCA        = "connect-ca"
+	JournalGossipKey        = "gossip-key"
+	JournalGossipPrimaryKey = "gossip-primary-key"
+	JournalNetworkArea      = "network-area"
+	JournalAutopilot        = "autopilot"
+)
+
+// JournalEntry records a single object that an import created or updated
+type JournalEntry struct {
+	Op        JournalOp `json:"op"`
+	Kind      string    `json:"kind"`
+	Partition string    `json:"partition,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	// ID identifies the object on the target. It is the id Consul assigned to the
+	// object or, for objects without one, its name or key.
+	ID string `json:"id,omitempty"`
+	// EntryKind is the kind of a config entry
+	EntryKind string `json:"entry_kind,omitempty"`
+	// Node is the node of a catalog service
+	Node string `json:"node,omitempty"`
+	// Previous is the object as it was before an update
+	Previous json.RawMessage `json:"previous,omitempty"`
+	// Object is the object as it was about to be created when the target assigns its
+	// id. Such a create is recorded without an id first and rolling it back finds the
+	// object by its name or contents instead.
+	Object json.RawMessage `json:"object,omitempty"`
+}
+
+// String describes the change for logging. Gossip encryption keys are left out.
+func (e JournalEntry) String() string {
+	name := e.ID
+	switch e.Kind {
+	case JournalGossipKey, JournalGossipPrimaryKey:
+		name = ""
+	case JournalConfigEntry:
+		name = e.EntryKind + "/" + e.ID
+	case JournalCatalogService:
+		name = e.Node + "/" + e.ID
+	}
+
+	desc := fmt.Sprintf("%s %s", e.Op, e.Kind)
+	if name != "" {
+		desc += " " + name
+	}
+	if e.Partition != "" {
+		desc += " (partition: " + e.Partition + ")"
+	}
+	if e.Namespace != "" {
+		desc += " (ns: " + e.Namespace + ")"
+	}
+	return desc
+}
+
+func (e *JournalEntry) writeOptions() *api.WriteOptions {
+	return &api.WriteOptions{Partition: e.Partition, Namespace: e.Namespace}
+}
+
+// Journal is an append-only record of the changes an import made to the target. Each
+// entry is written out before the change is made so that the journal covers an import
+// that fails halfway, even during a write. A nil Journal records nothing.
+type Journal struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+// OpenJournal opens the journal at the given path for appending, creating it if
+// needed. The journal holds the previous state of updated objects, including token
+// secrets, so it is only readable by its owner.
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+
+	return &Journal{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// record appends an entry to the journal and waits for it to be on disk
+func (j *Journal) record(entry JournalEntry) error {
+	if j == nil {
+		return nil
+	}
+
+	if err := j.enc.Encode(entry); err != nil {
+		return fmt.Errorf("error writing journal entry: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing journal: %w", err)
+	}
+	return nil
+}
+
+// ReadJournal reads all the entries of the journal at the given path in the order
+// they were recorded
+func ReadJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	// previous states of large objects such as KV values do not fit the default buffer
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error decoding journal entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+
+	return entries, nil
+}
+
+// journalCreate records an object the import is about to create
+func (imp *importer) journalCreate(entry JournalEntry) error {
+	entry.Op = JournalCreate
+	return imp.journal.record(entry)
+}
+
+// journalCreating records an object the import is about to create whose id the target
+// assigns. The id is recorded with journalCreate once the object is created, until then
+// the object itself identifies it.
+func (imp *importer) journalCreating(kind string, opts *api.WriteOptions, object interface{}) error {
+	if imp.journal == nil {
+		return nil
+	}
+
+	serialized, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("error serializing %s: %w", kind, err)
+	}
+
+	entry := scopedEntry(kind, "", opts)
+	entry.Op = JournalCreate
+	entry.Object = serialized
+	return imp.journal.record(entry)
+}
+
+// journalUpdate records an object the import is about to update along with its previous state
+func (imp *importer) journalUpdate(entry JournalEntry, previous interface{}) error {
+	if imp.journal == nil {
+		return nil
+	}
+
+	serialized, err := json.Marshal(previous)
+	if err != nil {
+		return fmt.Errorf("error serializing the previous state of %s %s: %w", entry.Kind, entry.ID, err)
+	}
+
+	entry.Op = JournalUpdate
+	entry.Previous = serialized
+	return imp.journal.record(entry)
+}
+
+// scopedEntry starts a journal entry for an object. The write options are those
+// the object was written with and give its partition and namespace.
+func scopedEntry(kind, id string, opts *api.WriteOptions) JournalEntry {
+	entry := JournalEntry{Kind: kind, ID: id}
+	if opts != nil {
+		entry.Partition = opts.Partition
+		entry.Namespace = opts.Namespace
+	}
+	return entry
+}
@@ -0,0 +1,124 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// PlanAction is what an import would do with a single object
+type PlanAction string
+
+const (
+	// PlanCreate creates the object as it does not exist on the target
+	PlanCreate PlanAction = "create"
+	// PlanUpdate overwrites the object of the target which differs
+	PlanUpdate PlanAction = "update"
+	// PlanNoOp leaves the object of the target alone as it already matches
+	PlanNoOp PlanAction = "no-op"
+	// PlanConflict is an object of the target which differs but is not
+	// overwritten. The detail of the change explains what happens instead.
+	PlanConflict PlanAction = "conflict"
+)
+
+// PlannedChange is what an import would do with a single object
+type PlannedChange struct {
+	Action    PlanAction `json:"action"`
+	Kind      string     `json:"kind"`
+	Name      string     `json:"name"`
+	Partition string     `json:"partition,omitempty"`
+	Namespace string     `json:"namespace,omitempty"`
+	Detail    string     `json:"detail,omitempty"`
+}
+
+// ImportPlan is what an import would do with the partitions, namespaces, ACL policies,
+// roles and tokens of the data
+type ImportPlan struct {
+	Changes []PlannedChange `json:"changes"`
+}
+
+// Count returns the number of changes with the given action
+func (p *ImportPlan) Count(action PlanAction) int {
+	count := 0
+	for _, change := range p.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// Plan computes what Import would do with the partitions, namespaces, ACL policies,
+// roles and tokens of the data. It runs the same reconciliation as Import with the
+// importer in plan mode, where every decision is recorded instead of written to the
+// target. Conflicts which would abort the import are listed rather than returned.
+func Plan(client *api.Client, data *Data, opts *ImportOptions) (*ImportPlan, error) {
+	planOpts := ImportOptions{}
+	if opts != nil {
+		planOpts = *opts
+	}
+	// nothing is written so there is nothing to record
+	planOpts.Journal = nil
+	planOpts.Checkpoint = nil
+
+	imp, err := newImporter(client, &planOpts)
+	if err != nil {
+		return nil, err
+	}
+	imp.plan = &ImportPlan{}
+
+	if err := imp.checkTokenLocality(data); err != nil {
+		return nil, err
+	}
+
+	if imp.enterprise {
+		_, err = imp.importEnterprise(data)
+	} else {
+		_, err = imp.importOSS(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return imp.plan, nil
+}
+
+// planChange records what the import would do with an object when planning. It does
+// nothing during an actual import.
+func (imp *importer) planChange(action PlanAction, kind, name, detail string) {
+	if imp.plan == nil {
+		return
+	}
+
+	change := PlannedChange{
+		Action:    action,
+		Kind:      kind,
+		Name:      name,
+		Partition: imp.partition,
+		Detail:    detail,
+	}
+	if imp.opts != nil {
+		change.Namespace = imp.opts.Namespace
+	}
+	imp.plan.Changes = append(imp.plan.Changes, change)
+}
+
+// planRenamable records what the import would do with a policy or role which is
+// imported under the given name. A renamed object is a conflict of its original name.
+func (imp *importer) planRenamable(action PlanAction, kind, name, importedAs string) {
+	if name == importedAs {
+		imp.planChange(action, kind, name, "")
+		return
+	}
+
+	detail := fmt.Sprintf("imported as %s", importedAs)
+	if action == PlanNoOp {
+		detail += " which is up to date"
+	}
+	imp.planChange(PlanConflict, kind, name, detail)
+}
+
+// plannedID stands in for the id of an object which does not exist yet
+func plannedID(sourceID string) string {
+	return "planned:" + sourceID
+}
@@ -0,0 +1,87 @@
+package migrate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestPlan(t *testing.T) {
+	var writes []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writes = append(writes, r.Method+" "+r.URL.Path)
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/v1/agent/self", func(w http.ResponseWriter, r *http.Request) {
+		// a secondary datacenter of an oss federation
+		json.NewEncoder(w).Encode(map[string]map[string]interface{}{
+			"Config": {"Version": "1.12.0", "Datacenter": "dc2", "PrimaryDatacenter": "dc1"},
+		})
+	})
+	mux.HandleFunc("/v1/catalog/datacenters", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]string{"dc1", "dc2"})
+	})
+	mux.HandleFunc("/v1/acl/policy/name/web", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(api.ACLPolicy{ID: "web-id", Name: "web", Rules: `node_prefix "" { policy = "read" }`})
+	})
+	mux.HandleFunc("/v1/acl/token/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &Data{
+		Datacenter: "dc2",
+		ScopedData: ScopedData{
+			ACLData: ACLData{
+				ACLPolicies: map[string]api.ACLPolicy{
+					"web-source": {ID: "web-source", Name: "web", Rules: `service_prefix "" { policy = "read" }`},
+					"db-source":  {ID: "db-source", Name: "db"},
+				},
+				ACLTokens: map[string]api.ACLToken{
+					"global": {AccessorID: "global", SecretID: "global-secret"},
+					"login":  {AccessorID: "login", SecretID: "login-secret", Local: true, AuthMethod: "kubernetes"},
+				},
+			},
+		},
+	}
+
+	plan, err := Plan(client, data, &ImportOptions{OnConflict: ConflictFail, AuthMethodTokens: AuthMethodTokensFail})
+	if err != nil {
+		t.Fatalf("expected the conflicts to be planned rather than fail, got %v", err)
+	}
+
+	if len(writes) != 0 {
+		t.Fatalf("expected nothing to be written, got %v", writes)
+	}
+
+	actions := make(map[string]PlanAction)
+	for _, change := range plan.Changes {
+		actions[change.Kind+"/"+change.Name] = change.Action
+	}
+	// the global token is replicated from the primary so it is left out
+	expected := map[string]PlanAction{
+		"ACL Policy/web":  PlanConflict,
+		"ACL Policy/db":   PlanCreate,
+		"ACL Token/login": PlanConflict,
+	}
+	for name, action := range expected {
+		if actions[name] != action {
+			t.Errorf("expected %s to be planned as %q, got %q", name, action, actions[name])
+		}
+	}
+	if len(plan.Changes) != len(expected) {
+		t.Errorf("expected %d changes, got %+v", len(expected), plan.Changes)
+	}
+}
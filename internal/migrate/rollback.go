@@ -0,0 +1,372 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+// Rollback undoes the changes recorded in a journal, newest first. Objects that were
+// created are deleted and objects that were updated are restored to their previous
+// state. Changes which cannot be undone are logged and skipped so that as much as
+// possible gets rolled back, an error then reports how many there were. Rolling back
+// the same journal again is safe.
+func Rollback(client *api.Client, entries []JournalEntry) error {
+	failed := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		logger := hclog.L().With("change", entry.String())
+
+		var err error
+		switch entry.Op {
+		case JournalCreate:
+			err = undoCreate(client, &entry)
+		case JournalUpdate:
+			err = undoUpdate(client, &entry)
+		default:
+			err = fmt.Errorf("unknown journal operation %q", entry.Op)
+		}
+
+		if err != nil {
+			logger.Error("failed to roll back change", "error", err)
+			failed++
+			continue
+		}
+		logger.Info("rolled back change")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to roll back %d of %d changes", failed, len(entries))
+	}
+	return nil
+}
+
+// undoCreate deletes an object which was created by the import. Objects which
+// no longer exist are already rolled back.
+func undoCreate(client *api.Client, entry *JournalEntry) error {
+	if entry.ID == "" && len(entry.Object) > 0 {
+		id, err := findCreated(client, entry)
+		if err != nil || id == "" {
+			return err
+		}
+		found := *entry
+		found.ID = id
+		entry = &found
+	}
+
+	opts := entry.writeOptions()
+
+	var err error
+	switch entry.Kind {
+	case JournalPartition:
+		_, err = client.Partitions().Delete(context.Background(), entry.ID, nil)
+	case JournalNamespace:
+		_, err = client.Namespaces().Delete(entry.ID, &api.WriteOptions{Partition: entry.Partition})
+	case JournalACLPolicy:
+		_, err = client.ACL().PolicyDelete(entry.ID, opts)
+	case JournalACLRole:
+		_, err = client.ACL().RoleDelete(entry.ID, opts)
+	case JournalACLAuthMethod:
+		_, err = client.ACL().AuthMethodDelete(entry.ID, opts)
+	case JournalACLBindingRule:
+		_, err = client.ACL().BindingRuleDelete(entry.ID, opts)
+	case JournalACLToken:
+		_, err = client.ACL().TokenDelete(entry.ID, opts)
+	case JournalKV:
+		_, err = client.KV().Delete(entry.ID, opts)
+	case JournalConfigEntry:
+		_, err = client.ConfigEntries().Delete(entry.EntryKind, entry.ID, opts)
+	case JournalIntention:
+		_, err = client.Connect().IntentionDelete(entry.ID, opts)
+	case JournalPreparedQuery:
+		_, err = client.PreparedQuery().Delete(entry.ID, nil)
+	case JournalCatalogNode:
+		_, err = client.Catalog().Deregister(&api.CatalogDeregistration{Node: entry.ID}, nil)
+	case JournalCatalogService:
+		_, err = client.Catalog().Deregister(&api.CatalogDeregistration{
+			Node:      entry.Node,
+			ServiceID: entry.ID,
+			Namespace: entry.Namespace,
+		}, nil)
+	case JournalNetworkArea:
+		_, err = client.Operator().AreaDelete(entry.ID, nil)
+	case JournalGossipKey:
+		err = client.Operator().KeyringRemove(entry.ID, nil)
+	default:
+		return fmt.Errorf("unknown journal kind %q", entry.Kind)
+	}
+
+	if err != nil && (isNotFoundError(err) || isACLNotFoundError(err)) {
+		return nil
+	}
+	if err != nil && entry.Kind == JournalConfigEntry && isUnsupportedKindError(err) {
+		// the target never supported the kind so the entry was skipped
+		return nil
+	}
+	return err
+}
+
+// findCreated returns the id of an object which the import was about to create when
+// the journal entry was recorded. It is found the same way the import finds existing
+// objects. An empty id means the object was never created or is already deleted.
+func findCreated(client *api.Client, entry *JournalEntry) (string, error) {
+	qopts := &api.QueryOptions{Partition: entry.Partition, Namespace: entry.Namespace}
+	decode := func(v interface{}) error {
+		if err := json.Unmarshal(entry.Object, v); err != nil {
+			return fmt.Errorf("error decoding the created object: %w", err)
+		}
+		return nil
+	}
+
+	switch entry.Kind {
+	case JournalACLPolicy:
+		var policy api.ACLPolicy
+		if err := decode(&policy); err != nil {
+			return "", err
+		}
+		existing, _, err := client.ACL().PolicyReadByName(policy.Name, qopts)
+		if err != nil || existing == nil {
+			return "", err
+		}
+		return existing.ID, nil
+	case JournalACLRole:
+		var role api.ACLRole
+		if err := decode(&role); err != nil {
+			return "", err
+		}
+		existing, _, err := client.ACL().RoleReadByName(role.Name, qopts)
+		if err != nil || existing == nil {
+			return "", err
+		}
+		return existing.ID, nil
+	case JournalACLBindingRule:
+		var rule api.ACLBindingRule
+		if err := decode(&rule); err != nil {
+			return "", err
+		}
+		rules, _, err := client.ACL().BindingRuleList(rule.AuthMethod, qopts)
+		if err != nil {
+			return "", err
+		}
+		if existing := findBindingRule(rules, &rule); existing != nil {
+			return existing.ID, nil
+		}
+	case JournalIntention:
+		var intention api.Intention
+		if err := decode(&intention); err != nil {
+			return "", err
+		}
+		// intentions are listed within the namespace of their destination
+		intentions, _, err := client.Connect().Intentions(&api.QueryOptions{
+			Partition: intention.DestinationPartition,
+			Namespace: intention.DestinationNS,
+		})
+		if err != nil {
+			return "", err
+		}
+		if existing := findIntention(intentions, &intention); existing != nil {
+			return existing.ID, nil
+		}
+	case JournalPreparedQuery:
+		var query api.PreparedQueryDefinition
+		if err := decode(&query); err != nil {
+			return "", err
+		}
+		queries, _, err := client.PreparedQuery().List(nil)
+		if err != nil {
+			return "", err
+		}
+		if existing := findPreparedQuery(queries, &query); existing != nil {
+			return existing.ID, nil
+		}
+	case JournalNetworkArea:
+		var area api.Area
+		if err := decode(&area); err != nil {
+			return "", err
+		}
+		areas, _, err := client.Operator().AreaList(nil)
+		if err != nil {
+			return "", err
+		}
+		for _, existing := range areas {
+			if existing.PeerDatacenter == area.PeerDatacenter {
+				return existing.ID, nil
+			}
+		}
+	default:
+		return "", fmt.Errorf("journal kind %q cannot be found without an id", entry.Kind)
+	}
+
+	return "", nil
+}
+
+// undoUpdate restores an object which was updated by the import to its previous state
+func undoUpdate(client *api.Client, entry *JournalEntry) error {
+	if len(entry.Previous) == 0 || string(entry.Previous) == "null" {
+		return fmt.Errorf("the journal holds no previous state")
+	}
+
+	opts := entry.writeOptions()
+	decode := func(v interface{}) error {
+		if err := json.Unmarshal(entry.Previous, v); err != nil {
+			return fmt.Errorf("error decoding the previous state: %w", err)
+		}
+		return nil
+	}
+
+	switch entry.Kind {
+	case JournalPartition:
+		var partition api.Partition
+		if err := decode(&partition); err != nil {
+			return err
+		}
+		_, _, err := client.Partitions().Update(context.Background(), &partition, nil)
+		return err
+	case JournalNamespace:
+		var ns api.Namespace
+		if err := decode(&ns); err != nil {
+			return err
+		}
+		_, _, err := client.Namespaces().Update(&ns, &api.WriteOptions{Partition: entry.Partition})
+		return err
+	case JournalACLPolicy:
+		var policy api.ACLPolicy
+		if err := decode(&policy); err != nil {
+			return err
+		}
+		_, _, err := client.ACL().PolicyUpdate(&policy, opts)
+		return err
+	case JournalACLRole:
+		var role api.ACLRole
+		if err := decode(&role); err != nil {
+			return err
+		}
+		_, _, err := client.ACL().RoleUpdate(&role, opts)
+		return err
+	case JournalACLAuthMethod:
+		var method api.ACLAuthMethod
+		if err := decode(&method); err != nil {
+			return err
+		}
+		_, _, err := client.ACL().AuthMethodUpdate(&method, opts)
+		return err
+	case JournalACLBindingRule:
+		var rule api.ACLBindingRule
+		if err := decode(&rule); err != nil {
+			return err
+		}
+		_, _, err := client.ACL().BindingRuleUpdate(&rule, opts)
+		return err
+	case JournalACLToken:
+		var token api.ACLToken
+		if err := decode(&token); err != nil {
+			return err
+		}
+		_, _, err := client.ACL().TokenUpdate(&token, opts)
+		return err
+	case JournalKV:
+		var pair api.KVPair
+		if err := decode(&pair); err != nil {
+			return err
+		}
+		pair.Session = ""
+		_, err := client.KV().Put(&pair, opts)
+		return err
+	case JournalConfigEntry:
+		configEntry, err := decodeConfigEntry(entry.Previous)
+		if err != nil {
+			return fmt.Errorf("error decoding the previous state: %w", err)
+		}
+		resetConfigEntry(configEntry)
+		if raw, ok := configEntry.(RawConfigEntry); ok {
+			_, err = client.Raw().Write("/v1/config", raw, nil, opts)
+		} else {
+			_, _, err = client.ConfigEntries().Set(configEntry, opts)
+		}
+		return err
+	case JournalIntention:
+		var intention api.Intention
+		if err := decode(&intention); err != nil {
+			return err
+		}
+		_, err := client.Connect().IntentionUpdate(&intention, opts)
+		return err
+	case JournalPreparedQuery:
+		var query api.PreparedQueryDefinition
+		if err := decode(&query); err != nil {
+			return err
+		}
+		_, err := client.PreparedQuery().Update(&query, nil)
+		return err
+	case JournalCatalogNode:
+		var node api.Node
+		if err := decode(&node); err != nil {
+			return err
+		}
+		_, err := client.Catalog().Register(&api.CatalogRegistration{
+			ID:              node.ID,
+			Node:            node.Node,
+			Address:         node.Address,
+			TaggedAddresses: node.TaggedAddresses,
+			NodeMeta:        node.Meta,
+		}, nil)
+		return err
+	case JournalCatalogService:
+		var service api.AgentService
+		if err := decode(&service); err != nil {
+			return err
+		}
+		// registrations need the address of the node even when it is left alone
+		node, _, err := client.Catalog().Node(entry.Node, nil)
+		if err != nil {
+			return fmt.Errorf("error looking up node %s: %w", entry.Node, err)
+		}
+		if node == nil || node.Node == nil {
+			return fmt.Errorf("node %s no longer exists", entry.Node)
+		}
+		_, err = client.Catalog().Register(&api.CatalogRegistration{
+			Node:           entry.Node,
+			Address:        node.Node.Address,
+			Service:        &service,
+			SkipNodeUpdate: true,
+		}, nil)
+		return err
+	case JournalConnectCA:
+		var config api.CAConfig
+		if err := decode(&config); err != nil {
+			return err
+		}
+		config.CreateIndex = 0
+		config.ModifyIndex = 0
+		config.State = nil
+		_, err := client.Connect().CASetConfig(&config, nil)
+		return err
+	case JournalGossipPrimaryKey:
+		var key string
+		if err := decode(&key); err != nil {
+			return err
+		}
+		if key == "" {
+			return fmt.Errorf("the target did not report its previous primary key")
+		}
+		return client.Operator().KeyringUse(key, nil)
+	case JournalNetworkArea:
+		var area api.Area
+		if err := decode(&area); err != nil {
+			return err
+		}
+		_, _, err := client.Operator().AreaUpdate(entry.ID, &area, nil)
+		return err
+	case JournalAutopilot:
+		var config api.AutopilotConfiguration
+		if err := decode(&config); err != nil {
+			return err
+		}
+		return client.Operator().AutopilotSetConfiguration(&config, nil)
+	default:
+		return fmt.Errorf("unknown journal kind %q", entry.Kind)
+	}
+}
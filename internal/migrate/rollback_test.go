@@ -0,0 +1,78 @@
+package migrate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestRollback_CreateWithoutID(t *testing.T) {
+	policies := map[string]*api.ACLPolicy{
+		"web": {ID: "web-id", Name: "web"},
+	}
+
+	var deleted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/acl/policy/name/", func(w http.ResponseWriter, r *http.Request) {
+		policy, ok := policies[r.URL.Path[len("/v1/acl/policy/name/"):]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(policy)
+	})
+	mux.HandleFunc("/v1/acl/policy/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			return
+		}
+		id := r.URL.Path[len("/v1/acl/policy/"):]
+		deleted = append(deleted, id)
+		for name, policy := range policies {
+			if policy.ID == id {
+				delete(policies, name)
+			}
+		}
+		w.Write([]byte("true"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object := func(name string) json.RawMessage {
+		serialized, err := json.Marshal(api.ACLPolicy{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serialized
+	}
+
+	entries := []JournalEntry{
+		// the import died before recording the id of the created policy
+		{Op: JournalCreate, Kind: JournalACLPolicy, Object: object("web")},
+		// the import died before creating the policy at all
+		{Op: JournalCreate, Kind: JournalACLPolicy, Object: object("db")},
+	}
+	if err := Rollback(client, entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(deleted) != 1 || deleted[0] != "web-id" {
+		t.Fatalf("expected only the created policy to be deleted, got %v", deleted)
+	}
+
+	// rolling back again finds nothing left to delete
+	if err := Rollback(client, entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 1 {
+		t.Fatalf("expected nothing else to be deleted, got %v", deleted)
+	}
+}
@@ -0,0 +1,366 @@
+package migrate
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// snapshotMessageType is the type of a record within the state of a snapshot. The
+// values are those of the raft message types used by Consul.
+type snapshotMessageType uint8
+
+const (
+	snapshotKVS            snapshotMessageType = 2
+	snapshotLegacyACL      snapshotMessageType = 4
+	snapshotACLToken       snapshotMessageType = 17
+	snapshotACLPolicy      snapshotMessageType = 19
+	snapshotACLRole        snapshotMessageType = 23
+	snapshotACLBindingRule snapshotMessageType = 25
+	snapshotACLAuthMethod  snapshotMessageType = 27
+
+	// snapshotIgnoreUnknownFlag is set on record types which older
+	// versions of Consul may safely ignore
+	snapshotIgnoreUnknownFlag snapshotMessageType = 128
+)
+
+// snapshotHandle decodes the msgpack encoded records the same way Consul does
+var snapshotHandle = &codec.MsgpackHandle{
+	RawToString: true,
+	BasicHandle: codec.BasicHandle{
+		DecodeOptions: codec.DecodeOptions{
+			MapType: reflect.TypeOf(map[string]interface{}{}),
+		},
+	},
+}
+
+// snapshotScope identifies the partition and namespace that a record belongs to
+type snapshotScope struct {
+	partition string
+	namespace string
+}
+
+// ExtractSnapshot reads a snapshot archive as written by `consul snapshot save` and
+// returns the ACL and KV data held within it. No running Consul is required.
+//
+// On Consul Enterprise the namespace definitions are extracted along with the data
+// within them. Partitions are recreated bare from the records which live within
+// them. The snapshot does not record its datacenter either.
+func ExtractSnapshot(in io.Reader) (*Data, error) {
+	state, err := readSnapshotState(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeSnapshotState(state)
+}
+
+// readSnapshotState returns the raft state of a snapshot archive after verifying
+// it against the archive's checksums.
+func readSnapshotState(in io.Reader) ([]byte, error) {
+	decomp, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing snapshot: %w", err)
+	}
+	defer decomp.Close()
+
+	var state bytes.Buffer
+	var sums []byte
+	metaHash := sha256.New()
+	stateHash := sha256.New()
+
+	archive := tar.NewReader(decomp)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading snapshot archive: %w", err)
+		}
+
+		switch hdr.Name {
+		case "meta.json":
+			if _, err := io.Copy(metaHash, archive); err != nil {
+				return nil, fmt.Errorf("error reading snapshot metadata: %w", err)
+			}
+		case "state.bin":
+			if _, err := io.Copy(io.MultiWriter(&state, stateHash), archive); err != nil {
+				return nil, fmt.Errorf("error reading snapshot state: %w", err)
+			}
+		case "SHA256SUMS":
+			if sums, err = ioutil.ReadAll(archive); err != nil {
+				return nil, fmt.Errorf("error reading snapshot checksums: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected file %q in snapshot archive", hdr.Name)
+		}
+	}
+
+	if sums == nil {
+		return nil, fmt.Errorf("snapshot archive is missing its checksums")
+	}
+
+	hashes := map[string][]byte{
+		"meta.json": metaHash.Sum(nil),
+		"state.bin": stateHash.Sum(nil),
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		var sum []byte
+		var file string
+		if _, err := fmt.Sscanf(scanner.Text(), "%x  %s", &sum, &file); err != nil {
+			return nil, fmt.Errorf("error parsing snapshot checksums: %w", err)
+		}
+
+		expected, ok := hashes[file]
+		if !ok {
+			return nil, fmt.Errorf("snapshot archive is missing %q", file)
+		}
+		if !bytes.Equal(sum, expected) {
+			return nil, fmt.Errorf("checksum mismatch for %q in snapshot archive", file)
+		}
+	}
+
+	return state.Bytes(), nil
+}
+
+// decodeSnapshotState decodes the records of interest from the raft state. The state
+// is a header followed by records that each start with their message type.
+func decodeSnapshotState(state []byte) (*Data, error) {
+	reader := bytes.NewReader(state)
+	dec := codec.NewDecoder(reader, snapshotHandle)
+
+	var header struct {
+		LastIndex uint64
+	}
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("error decoding snapshot header: %w", err)
+	}
+	hclog.L().Debug("decoding snapshot", "index", header.LastIndex)
+
+	scopes := make(map[snapshotScope]*ScopedData)
+	scopeOf := func(partition, namespace string) *ScopedData {
+		key := snapshotScope{partition: scopePartition(partition), namespace: namespace}
+		if scoped, ok := scopes[key]; ok {
+			return scoped
+		}
+		scoped := &ScopedData{
+			ACLData: ACLData{
+				ACLPolicies:     make(map[string]api.ACLPolicy),
+				ACLRoles:        make(map[string]api.ACLRole),
+				ACLTokens:       make(map[string]api.ACLToken),
+				ACLAuthMethods:  make(map[string]api.ACLAuthMethod),
+				ACLBindingRules: make(map[string]api.ACLBindingRule),
+			},
+		}
+		scopes[key] = scoped
+		return scoped
+	}
+	namespaces := make(map[snapshotScope]api.Namespace)
+
+	for {
+		msgType, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading snapshot record: %w", err)
+		}
+
+		switch snapshotMessageType(msgType) &^ snapshotIgnoreUnknownFlag {
+		case snapshotKVS:
+			var pair api.KVPair
+			if err := dec.Decode(&pair); err != nil {
+				return nil, fmt.Errorf("error decoding kv entry: %w", err)
+			}
+			scoped := scopeOf(pair.Partition, pair.Namespace)
+			scoped.KV = append(scoped.KV, &pair)
+		case snapshotACLToken:
+			var token api.ACLToken
+			if err := dec.Decode(&token); err != nil {
+				return nil, fmt.Errorf("error decoding acl token: %w", err)
+			}
+			if token.Rules != "" {
+				hclog.L().Warn("skipping legacy ACL token as its rules can only be translated by a running Consul",
+					"accessor-id", token.AccessorID, "description", token.Description)
+				continue
+			}
+			scopeOf(token.Partition, token.Namespace).ACLTokens[token.AccessorID] = token
+		case snapshotACLPolicy:
+			var policy api.ACLPolicy
+			if err := dec.Decode(&policy); err != nil {
+				return nil, fmt.Errorf("error decoding acl policy: %w", err)
+			}
+			if policy.ID == "00000000-0000-0000-0000-000000000001" {
+				// no need to save off the global-management policy
+				continue
+			}
+			scopeOf(policy.Partition, policy.Namespace).ACLPolicies[policy.ID] = policy
+		case snapshotACLRole:
+			var role api.ACLRole
+			if err := dec.Decode(&role); err != nil {
+				return nil, fmt.Errorf("error decoding acl role: %w", err)
+			}
+			scopeOf(role.Partition, role.Namespace).ACLRoles[role.ID] = role
+		case snapshotACLAuthMethod:
+			var method api.ACLAuthMethod
+			if err := dec.Decode(&method); err != nil {
+				return nil, fmt.Errorf("error decoding acl auth method: %w", err)
+			}
+			scopeOf(method.Partition, method.Namespace).ACLAuthMethods[method.Name] = method
+		case snapshotACLBindingRule:
+			var rule api.ACLBindingRule
+			if err := dec.Decode(&rule); err != nil {
+				return nil, fmt.Errorf("error decoding acl binding rule: %w", err)
+			}
+			scopeOf(rule.Partition, rule.Namespace).ACLBindingRules[rule.ID] = rule
+		case snapshotLegacyACL:
+			// the id of a legacy token is its secret so only the name is logged
+			var token struct {
+				Name string
+			}
+			if err := dec.Decode(&token); err != nil {
+				return nil, fmt.Errorf("error decoding legacy acl token: %w", err)
+			}
+			hclog.L().Warn("skipping legacy ACL token as its rules can only be translated by a running Consul",
+				"name", token.Name)
+		default:
+			// everything else is decoded generically, either to find the namespaces
+			// or just to get past it
+			var record interface{}
+			if err := dec.Decode(&record); err != nil {
+				return nil, fmt.Errorf("error decoding snapshot record of type %d: %w", msgType, err)
+			}
+			namespace, ok, err := snapshotNamespace(record)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				scopeOf(namespace.Partition, namespace.Name)
+				namespaces[snapshotScope{partition: scopePartition(namespace.Partition), namespace: namespace.Name}] = namespace
+			}
+		}
+	}
+
+	return snapshotData(scopes, namespaces), nil
+}
+
+// snapshotNamespace returns the namespace definition held by a generically decoded
+// record. Consul Enterprise stores namespaces under a message type which is not part
+// of the public Consul source, so they are recognized by the fields which only a
+// namespace has. Namespaces which are being deleted are left out.
+func snapshotNamespace(record interface{}) (api.Namespace, bool, error) {
+	var namespace api.Namespace
+
+	fields, ok := record.(map[string]interface{})
+	if !ok {
+		return namespace, false, nil
+	}
+	for _, field := range []string{"Name", "ACLs", "DeletedAt"} {
+		if _, ok := fields[field]; !ok {
+			return namespace, false, nil
+		}
+	}
+	if fields["DeletedAt"] != nil {
+		hclog.L().Debug("skipping namespace marked for deletion", "namespace", fields["Name"])
+		return namespace, false, nil
+	}
+	delete(fields, "DeletedAt")
+
+	// the generic record is converted through JSON which shares the field names
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return namespace, false, fmt.Errorf("error encoding namespace record: %w", err)
+	}
+	if err := json.Unmarshal(raw, &namespace); err != nil {
+		return namespace, false, fmt.Errorf("error decoding namespace record: %w", err)
+	}
+	if namespace.Name == "" {
+		return namespace, false, nil
+	}
+	namespace.Partition = scopePartition(namespace.Partition)
+	namespace.CreateIndex = 0
+	namespace.ModifyIndex = 0
+	return namespace, true, nil
+}
+
+// scopePartition returns how a partition is identified within the decoded scopes,
+// where the default partition has no name
+func scopePartition(partition string) string {
+	if partition == api.PartitionDefaultName {
+		return ""
+	}
+	return partition
+}
+
+// snapshotData arranges the scoped data decoded from a snapshot into partitions and
+// namespaces along with the namespace definitions. Records from Consul OSS carry no
+// namespace so all of them end up within the top level scoped data.
+func snapshotData(scopes map[snapshotScope]*ScopedData, namespaces map[snapshotScope]api.Namespace) *Data {
+	data := &Data{}
+
+	enterprise := false
+	for scope := range scopes {
+		if scope != (snapshotScope{}) {
+			enterprise = true
+			break
+		}
+	}
+
+	if !enterprise {
+		if scoped, ok := scopes[snapshotScope{}]; ok {
+			data.ScopedData = *scoped
+		}
+		return data
+	}
+
+	data.Enterprise = true
+	data.Namespaces = make(map[string]NamespaceData)
+	for scope, scoped := range scopes {
+		namespace := scope.namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+
+		definition, ok := namespaces[snapshotScope{partition: scope.partition, namespace: namespace}]
+		if !ok {
+			definition = api.Namespace{Name: namespace, Partition: scope.partition}
+		}
+		nsData := NamespaceData{
+			Definition: definition,
+			ScopedData: *scoped,
+		}
+
+		if scope.partition == "" {
+			data.Namespaces[namespace] = nsData
+			continue
+		}
+
+		if data.Partitions == nil {
+			data.Partitions = make(map[string]PartitionData)
+		}
+		partition, ok := data.Partitions[scope.partition]
+		if !ok {
+			partition = PartitionData{
+				Definition: api.Partition{Name: scope.partition},
+				Namespaces: make(map[string]NamespaceData),
+			}
+		}
+		partition.Namespaces[namespace] = nsData
+		data.Partitions[scope.partition] = partition
+	}
+
+	return data
+}
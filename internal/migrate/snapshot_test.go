@@ -0,0 +1,380 @@
+package migrate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// The types below mirror the layouts of the structs which Consul writes into the state
+// of a snapshot, as of Consul 1.12, so that the fixtures are encoded the way Consul
+// encodes its records. RaftIndex and EnterpriseMeta are embedded and thereby flattened
+// into the records, which only happens for exported types like in Consul.
+
+type RaftIndex struct {
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// EnterpriseMeta is empty on Consul OSS, so records from OSS have neither field
+type EnterpriseMeta struct {
+	Partition string
+	Namespace string
+}
+
+type stateDirEntry struct {
+	LockIndex uint64
+	Key       string
+	Flags     uint64
+	Value     []byte
+	Session   string
+	EnterpriseMeta
+	RaftIndex
+}
+
+type stateACLPolicy struct {
+	ID          string
+	Name        string
+	Description string
+	Rules       string
+	Syntax      string
+	Datacenters []string
+	Hash        []byte
+	EnterpriseMeta
+	RaftIndex
+}
+
+type stateACLLink struct {
+	ID   string
+	Name string
+}
+
+type stateACLServiceIdentity struct {
+	ServiceName string
+	Datacenters []string
+}
+
+type stateACLToken struct {
+	AccessorID        string
+	SecretID          string
+	Description       string
+	Policies          []stateACLLink
+	Roles             []stateACLLink
+	ServiceIdentities []*stateACLServiceIdentity
+	Type              string
+	Rules             string
+	Local             bool
+	AuthMethod        string
+	ExpirationTime    *time.Time
+	ExpirationTTL     time.Duration
+	CreateTime        time.Time
+	Hash              []byte
+	EnterpriseMeta
+	RaftIndex
+}
+
+type stateACLRole struct {
+	ID                string
+	Name              string
+	Description       string
+	Policies          []stateACLLink
+	ServiceIdentities []*stateACLServiceIdentity
+	Hash              []byte
+	EnterpriseMeta
+	RaftIndex
+}
+
+type stateACLAuthMethod struct {
+	Name          string
+	DisplayName   string
+	Type          string
+	Description   string
+	MaxTokenTTL   time.Duration
+	TokenLocality string
+	Config        map[string]interface{}
+	EnterpriseMeta
+	RaftIndex
+}
+
+type stateACLBindingRule struct {
+	ID          string
+	Description string
+	AuthMethod  string
+	Selector    string
+	BindType    string
+	BindName    string
+	EnterpriseMeta
+	RaftIndex
+}
+
+// stateLegacyACL is a token of the legacy ACL system. Its ID is the secret.
+type stateLegacyACL struct {
+	ID    string
+	Name  string
+	Type  string
+	Rules string
+	RaftIndex
+}
+
+type stateNamespaceACLConfig struct {
+	PolicyDefaults []stateACLLink
+	RoleDefaults   []stateACLLink
+}
+
+type stateNamespace struct {
+	Name        string
+	Description string
+	ACLs        *stateNamespaceACLConfig
+	Meta        map[string]string
+	DeletedAt   *time.Time
+	Partition   string
+	RaftIndex
+}
+
+type stateIntention struct {
+	ID              string
+	Description     string
+	SourceNS        string
+	SourceName      string
+	DestinationNS   string
+	DestinationName string
+	SourceType      string
+	Action          string
+	Meta            map[string]string
+	Precedence      int
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Hash            []byte
+	RaftIndex
+}
+
+// snapshotRecord is a record written into the state of a fixture snapshot
+type snapshotRecord struct {
+	msgType snapshotMessageType
+	value   interface{}
+}
+
+// fixtureSnapshot builds a snapshot archive laid out the way `consul snapshot save`
+// writes it, holding the given records
+func fixtureSnapshot(t *testing.T, records []snapshotRecord) *bytes.Buffer {
+	t.Helper()
+
+	var state bytes.Buffer
+	enc := codec.NewEncoder(&state, snapshotHandle)
+	if err := enc.Encode(struct{ LastIndex uint64 }{LastIndex: 42}); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		state.WriteByte(byte(record.msgType))
+		if err := enc.Encode(record.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"meta.json", []byte(`{"Version":1,"Index":42,"Term":2}`)},
+		{"state.bin", state.Bytes()},
+	}
+	var sums bytes.Buffer
+	for _, file := range files {
+		fmt.Fprintf(&sums, "%x  %s\n", sha256.Sum256(file.data), file.name)
+	}
+	files = append(files, struct {
+		name string
+		data []byte
+	}{"SHA256SUMS", sums.Bytes()})
+
+	var archive bytes.Buffer
+	comp := gzip.NewWriter(&archive)
+	writer := tar.NewWriter(comp)
+	for _, file := range files {
+		hdr := &tar.Header{Name: file.name, Mode: 0600, Size: int64(len(file.data))}
+		if err := writer.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := comp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &archive
+}
+
+func TestExtractSnapshot(t *testing.T) {
+	created := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	expires := created.Add(24 * time.Hour)
+	raft := RaftIndex{CreateIndex: 10, ModifyIndex: 12}
+
+	archive := fixtureSnapshot(t, []snapshotRecord{
+		{snapshotKVS, stateDirEntry{Key: "app/config", Value: []byte("value"), Flags: 3, LockIndex: 1, RaftIndex: raft}},
+		{snapshotACLPolicy, stateACLPolicy{ID: "00000000-0000-0000-0000-000000000001", Name: "global-management", RaftIndex: raft}},
+		{snapshotACLPolicy, stateACLPolicy{ID: "policy-id", Name: "web", Rules: `service "web" { policy = "write" }`,
+			Syntax: "current", Datacenters: []string{"dc1"}, Hash: []byte{1, 2}, RaftIndex: raft}},
+		{snapshotACLRole, stateACLRole{ID: "role-id", Name: "web", Policies: []stateACLLink{{ID: "policy-id", Name: "web"}},
+			ServiceIdentities: []*stateACLServiceIdentity{{ServiceName: "web", Datacenters: []string{"dc1"}}}, RaftIndex: raft}},
+		{snapshotACLToken, stateACLToken{AccessorID: "token-accessor", SecretID: "token-secret", Roles: []stateACLLink{{ID: "role-id", Name: "web"}},
+			Local: true, CreateTime: created, ExpirationTime: &expires, Hash: []byte{3}, RaftIndex: raft}},
+		// a legacy token which was upgraded to a new token but kept its rules
+		{snapshotACLToken, stateACLToken{AccessorID: "legacy-accessor", SecretID: "legacy-secret", Type: "client",
+			Rules: `key "" { policy = "read" }`, CreateTime: created, RaftIndex: raft}},
+		{snapshotLegacyACL, stateLegacyACL{ID: "legacy-secret", Name: "legacy", Type: "client", Rules: `key "" { policy = "read" }`, RaftIndex: raft}},
+		{snapshotACLAuthMethod, stateACLAuthMethod{Name: "kubernetes", Type: "kubernetes", MaxTokenTTL: time.Hour, TokenLocality: "global",
+			Config: map[string]interface{}{"Host": "https://k8s", "ClaimMappings": map[string]interface{}{"sub": "user"}}, RaftIndex: raft}},
+		{snapshotACLBindingRule, stateACLBindingRule{ID: "rule-id", AuthMethod: "kubernetes", BindType: string(api.BindingRuleBindTypeService),
+			BindName: "web", Selector: "serviceaccount.name==web", RaftIndex: raft}},
+		// an intention is of no interest and only has to be skipped
+		{12, stateIntention{ID: "intention-id", SourceName: "web", DestinationName: "db", Action: "allow",
+			Meta: map[string]string{"owner": "web"}, CreatedAt: created, UpdatedAt: created, RaftIndex: raft}},
+	})
+
+	data, err := ExtractSnapshot(archive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if data.Enterprise {
+		t.Fatal("expected data without namespaces to not be enterprise data")
+	}
+	if len(data.KV) != 1 || data.KV[0].Key != "app/config" || string(data.KV[0].Value) != "value" || data.KV[0].Flags != 3 {
+		t.Fatalf("unexpected kv data: %+v", data.KV)
+	}
+	if len(data.ACLPolicies) != 1 || data.ACLPolicies["policy-id"].Name != "web" || data.ACLPolicies["policy-id"].Datacenters[0] != "dc1" {
+		t.Fatalf("expected only the web policy, got %+v", data.ACLPolicies)
+	}
+	role := data.ACLRoles["role-id"]
+	if len(data.ACLRoles) != 1 || role.Policies[0].ID != "policy-id" || role.ServiceIdentities[0].ServiceName != "web" {
+		t.Fatalf("unexpected roles: %+v", data.ACLRoles)
+	}
+
+	if len(data.ACLTokens) != 1 {
+		t.Fatalf("expected only the token without legacy rules, got %+v", data.ACLTokens)
+	}
+	token := data.ACLTokens["token-accessor"]
+	if token.SecretID != "token-secret" || !token.Local || token.Roles[0].ID != "role-id" {
+		t.Fatalf("unexpected token: %+v", token)
+	}
+	if !token.CreateTime.Equal(created) || token.ExpirationTime == nil || !token.ExpirationTime.Equal(expires) {
+		t.Fatalf("expected the token times to be decoded, got %s and %v", token.CreateTime, token.ExpirationTime)
+	}
+
+	method, ok := data.ACLAuthMethods["kubernetes"]
+	if !ok || method.MaxTokenTTL != time.Hour || method.TokenLocality != "global" || method.Config["Host"] != "https://k8s" {
+		t.Fatalf("unexpected auth method: %+v", data.ACLAuthMethods)
+	}
+	if mappings, ok := method.Config["ClaimMappings"].(map[string]interface{}); !ok || mappings["sub"] != "user" {
+		t.Fatalf("expected the nested auth method config to be decoded, got %#v", method.Config["ClaimMappings"])
+	}
+	if rule := data.ACLBindingRules["rule-id"]; rule.BindName != "web" || rule.Selector != "serviceaccount.name==web" {
+		t.Fatalf("unexpected binding rules: %+v", data.ACLBindingRules)
+	}
+}
+
+func TestExtractSnapshot_Namespaces(t *testing.T) {
+	deletedAt := time.Now()
+	archive := fixtureSnapshot(t, []snapshotRecord{
+		// namespaces are recognized by their fields so any unknown message type will do
+		{64, stateNamespace{
+			Name:        "team",
+			Description: "the team namespace",
+			Meta:        map[string]string{"owner": "team"},
+			ACLs: &stateNamespaceACLConfig{
+				PolicyDefaults: []stateACLLink{{ID: "policy-id", Name: "web"}},
+			},
+			Partition: "default",
+			RaftIndex: RaftIndex{CreateIndex: 10, ModifyIndex: 11},
+		}},
+		{64, stateNamespace{Name: "bare", Partition: "default"}},
+		{64, stateNamespace{Name: "gone", Partition: "default", DeletedAt: &deletedAt}},
+		{snapshotKVS, stateDirEntry{Key: "app/config", Value: []byte("value"), EnterpriseMeta: EnterpriseMeta{Namespace: "team", Partition: "default"}}},
+		{snapshotACLPolicy, stateACLPolicy{ID: "policy-id", Name: "web", EnterpriseMeta: EnterpriseMeta{Namespace: "team", Partition: "default"}}},
+		{snapshotKVS, stateDirEntry{Key: "other", Value: []byte("value"), EnterpriseMeta: EnterpriseMeta{Namespace: "default", Partition: "other"}}},
+	})
+
+	data, err := ExtractSnapshot(archive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !data.Enterprise {
+		t.Fatal("expected enterprise data")
+	}
+	if _, ok := data.Namespaces["gone"]; ok {
+		t.Fatal("expected the namespace marked for deletion to be left out")
+	}
+	if _, ok := data.Namespaces["bare"]; !ok {
+		t.Fatalf("expected the namespace without settings, got %+v", data.Namespaces)
+	}
+
+	team, ok := data.Namespaces["team"]
+	if !ok {
+		t.Fatalf("expected the team namespace, got %+v", data.Namespaces)
+	}
+	if team.Definition.Description != "the team namespace" || team.Definition.Meta["owner"] != "team" {
+		t.Fatalf("expected the namespace definition to be kept, got %+v", team.Definition)
+	}
+	if team.Definition.ACLs == nil || len(team.Definition.ACLs.PolicyDefaults) != 1 || team.Definition.ACLs.PolicyDefaults[0].ID != "policy-id" {
+		t.Fatalf("expected the namespace acl defaults to be kept, got %+v", team.Definition.ACLs)
+	}
+	if team.Definition.Partition != "" || team.Definition.CreateIndex != 0 {
+		t.Fatalf("expected the namespace to be in the default partition without indexes, got %+v", team.Definition)
+	}
+	if len(team.KV) != 1 || len(team.ACLPolicies) != 1 {
+		t.Fatalf("expected the namespace data, got %+v", team.ScopedData)
+	}
+
+	other, ok := data.Partitions["other"]
+	if !ok || len(other.Namespaces["default"].KV) != 1 {
+		t.Fatalf("expected the other partition with its kv data, got %+v", data.Partitions)
+	}
+}
+
+func TestExtractSnapshot_ChecksumMismatch(t *testing.T) {
+	archive := fixtureSnapshot(t, nil)
+
+	// corrupt the state by rebuilding the archive with a different state.bin
+	comp, err := gzip.NewReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var corrupted bytes.Buffer
+	recomp := gzip.NewWriter(&corrupted)
+	reader := tar.NewReader(comp)
+	writer := tar.NewWriter(recomp)
+	for {
+		hdr, err := reader.Next()
+		if err != nil {
+			break
+		}
+		var contents bytes.Buffer
+		contents.ReadFrom(reader)
+		if hdr.Name == "state.bin" {
+			contents.WriteByte(byte(snapshotKVS))
+		}
+		hdr.Size = int64(contents.Len())
+		writer.WriteHeader(hdr)
+		writer.Write(contents.Bytes())
+	}
+	writer.Close()
+	recomp.Close()
+
+	_, err = ExtractSnapshot(&corrupted)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch error, got %v", err)
+	}
+}